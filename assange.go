@@ -7,6 +7,7 @@ import (
 	. "Assange/explorer"
 	. "Assange/logging"
 	//. "Assange/util"
+	"Assange/webhook"
 	. "Assange/zmq"
 	//"encoding/hex"
 	"encoding/json"
@@ -16,8 +17,6 @@ import (
 	"time"
 	//"github.com/conformal/btcutil"
 	//"github.com/conformal/btcwire"
-	"github.com/conformal/btcnet"
-	"github.com/conformal/btcscript"
 	"github.com/coopernurse/gorp"
 	. "strconv"
 	//"time"
@@ -32,6 +31,18 @@ var log = GetLogger("Main", DEBUG)
 
 var buildblockFlag bool
 var checkblockFlag bool
+var migrateFlag bool
+var bulkFlag bool
+var p2pFlag bool
+
+const (
+	//Transactions extracted per DB transaction in bulk mode.
+	bulkTxsPerCommit = 1000
+	//Used when Bulk_tip_distance is not configured.
+	defaultBulkTipDistance = 1000
+	//Txins read per query while resolving spends.
+	txinsPerBatch = 1000
+)
 
 func init() {
 	const (
@@ -40,9 +51,21 @@ func init() {
 
 		checkblockDefault = false
 		checkblockUsage   = "Check the relationship between block and tx."
+
+		migrateDefault = false
+		migrateUsage   = "Apply pending database schema migrations and exit."
+
+		bulkDefault = false
+		bulkUsage   = "Use bulk inserts and deferred indexes while far from the chain tip."
+
+		p2pDefault = false
+		p2pUsage   = "Sync blocks and txs from the P2P peer in p2p_peer instead of bitcoind RPC."
 	)
 	flag.BoolVar(&buildblockFlag, "buildblock", buildblockDefault, buildblockUsage)
 	flag.BoolVar(&checkblockFlag, "checkblock", checkblockDefault, checkblockUsage)
+	flag.BoolVar(&migrateFlag, "migrate", migrateDefault, migrateUsage)
+	flag.BoolVar(&bulkFlag, "bulk", bulkDefault, bulkUsage)
+	flag.BoolVar(&p2pFlag, "p2p", p2pDefault, p2pUsage)
 }
 
 func main() {
@@ -50,57 +73,188 @@ func main() {
 	var wait sync.WaitGroup
 	wait.Add(1)
 	Config, _ = config.InitConfiguration("config.json")
-	InitRpcClient(Config)
+	SetTestnet(Config.P2p_testnet)
+	if err := InitRpcClient(Config); err != nil {
+		log.Critical(err.Error())
+		return
+	}
 	dbmap, _ := InitDb(Config)
 	InitTables(dbmap)
-	InitZmq(dbmap)
+	if migrateFlag {
+		if err := MigrateDb(dbmap); err != nil {
+			log.Critical(err.Error())
+		}
+		return
+	}
+	if err := CheckSchema(dbmap); err != nil {
+		log.Critical(err.Error())
+		return
+	}
+	queries, err := PrepareQueries(dbmap.Db)
+	if err != nil {
+		log.Critical(err.Error())
+		return
+	}
 	go InitExplorerServer(Config)
-	if buildblockFlag {
-		buildBlock(dbmap, 50000)
+	go webhook.NewWorker(dbmap, Config.Webhook_allow_private).Run()
+	if p2pFlag {
+		ix := newIndexer(dbmap, queries)
+		SetTxIndexer(ix.storeSentTx)
+		go syncP2p(ix)
+	} else if buildblockFlag {
+		dbHeight, _ := GetMaxBlockHeightFromDB(dbmap)
+		bulk := bulkFlag && farFromTip(dbHeight)
+		if err := newIndexer(dbmap, queries).rollBack(); err != nil {
+			log.Error(err.Error())
+		}
+		if err := buildBlock(dbmap, queries, 50000); err != nil {
+			log.Error(err.Error())
+		}
 		buildTxFromBlock(dbmap)
+		if bulk {
+			if err := extractTxBulk(dbmap); err != nil {
+				log.Critical(err.Error())
+				return
+			}
+		}
+		//Bulk mode leaves the txs near the tip to the transactional path.
 		extractTx(dbmap)
-		extractTxout(dbmap)
-		extractTxin(dbmap)
+		cache := NewUtxoCache(Config.Utxo_cache_mb, queries)
+		extractTxout(dbmap, queries, cache)
+		extractTxin(dbmap, cache)
+		if err := QueueWebhooks(dbmap); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	//Started after -buildblock so that only one writer works on the index.
+	if !p2pFlag && ZmqEnabled(Config) {
+		ix := newIndexer(dbmap, queries)
+		if err := InitZmq(Config, &zmqHandler{ix}); err != nil {
+			log.Error(err.Error())
+		} else {
+			SetTxIndexer(ix.storeSentTx)
+			go HandleZmq()
+		}
 	}
 	if checkblockFlag {
 		checkBlock(dbmap)
 	}
 	wait.Wait()
-	//HandleZmq()
 }
 
-func buildBlock(dbmap *gorp.DbMap, height int64) {
+// Bulk mode only pays off during initial sync. Near the tip the per-block
+// transactional path is used so every block is committed on its own.
+// dbHeight is how far the DB got.
+func farFromTip(dbHeight int64) bool {
+	distance := Config.Bulk_tip_distance
+	if distance == 0 {
+		distance = defaultBulkTipDistance
+	}
+	bcHeight, err := RpcGetblockcount()
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	if bcHeight-dbHeight > distance {
+		log.Info("DB is %d blocks behind the tip, use bulk mode.", bcHeight-dbHeight)
+		return true
+	}
+	log.Info("DB is %d blocks behind the tip, use transactional mode.", bcHeight-dbHeight)
+	return false
+}
+
+func buildBlock(dbmap *gorp.DbMap, queries *Queries, height int64) error {
 	var bcHeight int64
 	var dbHeight int64
-	var rpcResult map[string]interface{}
-	var hashFromIdx string
+	var err error
 	if height == 0 {
-		bcHeight, _ = ParseInt(string(RpcGetblockcount()["result"].(json.Number)), 10, 64)
+		if bcHeight, err = RpcGetblockcount(); err != nil {
+			return err
+		}
 	} else {
 		bcHeight = height
 	}
 	dbHeight, _ = GetMaxBlockHeightFromDB(dbmap)
+	nextHash := ""
 	for dbHeight < bcHeight {
 		dbHeight++
-		trans, _ := dbmap.Begin()
 
-		//Get block info by height
-		rpcResult = RpcGetblockhash(dbHeight)
-		hashFromIdx = rpcResult["result"].(string)
-		rpcResult = RpcGetblock(hashFromIdx)
-		result := rpcResult["result"].(map[string]interface{})
+		//Get block info by height, unless the previous block told us its successor
+		hashFromIdx := nextHash
+		if hashFromIdx == "" {
+			if hashFromIdx, err = RpcGetblockhash(dbHeight); err != nil {
+				return err
+			}
+		}
+		block, raws, next, err := fetchBlock(hashFromIdx)
+		if err != nil {
+			return err
+		}
+		nextHash = next
+
+		//With several backends, stop rather than insert a block they disagree on
+		if err := RpcCheckBlockhash(dbHeight, hashFromIdx); err != nil {
+			return fmt.Errorf("Block %s at height %d: %s", hashFromIdx, dbHeight, err.Error())
+		}
 
-		//Make new ModelBlock from rpc result
-		block := new(ModelBlock)
-		block.NewFromMap(result)
 		//Insert into DB, and tx's id will be updated
 		//InsertBlockOnlyIntoDb(trans, block)
-		block.InsertIntoDb(trans)
+		trans, _ := dbmap.Begin()
+		if err := block.InsertIntoDb(trans, queries); err != nil {
+			trans.Rollback()
+			return fmt.Errorf("Block %s at height %d: %s", hashFromIdx, dbHeight, err.Error())
+		}
+		if !block.Extracted {
+			if err := insertBlockRawTxs(trans, block, raws); err != nil {
+				trans.Rollback()
+				return err
+			}
+		}
+		if err := trans.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		trans.Commit()
+// fetchBlock returns the block with its raw txs. With Rpc_block_verbosity 2
+// this is a single getblock call, otherwise getblock plus batched
+// getrawtransaction calls.
+func fetchBlock(hash string) (*ModelBlock, []string, string, error) {
+	block := new(ModelBlock)
+	var raws []string
+	if Config.Rpc_block_verbosity == 2 {
+		result, err := RpcGetblockVerbose(hash)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if err := block.NewFromRpc(&result.Block); err != nil {
+			return nil, nil, "", err
+		}
+		for _, tx := range result.Tx {
+			raws = append(raws, tx.Hex)
+		}
+		return block, raws, result.NextBlockHash, nil
+	}
+
+	result, err := RpcGetblock(hash)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := block.NewFromRpc(result); err != nil {
+		return nil, nil, "", err
 	}
+	//The genesis coinbase can not be fetched, InsertIntoDb marks it extracted.
+	if block.Height != 0 {
+		if raws, err = RpcGetrawtransactions(result.Tx); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	return block, raws, result.NextBlockHash, nil
 }
 
+// Blocks are normally extracted right after they are inserted by buildBlock,
+// this picks up blocks left unextracted by an interrupted run.
 func buildTxFromBlock(dbmap *gorp.DbMap) {
 	for {
 		trans, _ := dbmap.Begin()
@@ -109,38 +263,72 @@ func buildTxFromBlock(dbmap *gorp.DbMap) {
 		block := new(ModelBlock)
 		err := block.NewFromUnextracted(trans)
 		if err != nil {
+			trans.Rollback()
+			break
+		}
+		_, raws, _, err := fetchBlock(block.Hash.String())
+		if err != nil {
+			log.Error(err.Error())
+			trans.Rollback()
+			break
+		}
+		if err := insertBlockRawTxs(trans, block, raws); err != nil {
+			log.Error(err.Error())
+			trans.Rollback()
 			break
 		}
+		trans.Commit()
+	}
+}
+
+func insertBlockRawTxs(trans *gorp.Transaction, block *ModelBlock, results []string) error {
+	var txs []*ModelTx
+	for _, result := range results {
+		tx := new(ModelTx)
+		if err := tx.NewFromString(result); err != nil {
+			return err
+		}
+		txs = append(txs, tx)
+	}
+	//Without -txindex extractTx could not fetch the tx again, so extract it now.
+	return insertBlockTxs(trans, block, txs, Config.Rpc_block_verbosity == 2)
+}
 
-		for idx, txnHash := range RpcGetblockTxns(block.Hash) {
-			resp := RpcGetrawtransaction(txnHash)
-			result, ok := resp["result"].(string)
-			if !ok {
-				log.Error("Type assert error. tx.Hash:%s.", txnHash)
-				continue
+// insertBlockTxs stores the txs of block and links them to it. With extract
+// set their inputs and outputs are stored as well. Txs already known from
+// the mempool are only marked confirmed.
+func insertBlockTxs(trans *gorp.Transaction, block *ModelBlock, txs []*ModelTx, extract bool) error {
+	for idx, tx := range txs {
+		known := new(ModelTx)
+		if err := trans.SelectOne(known, "select * from tx where Hash=?", tx.Hash); err == nil {
+			known.Msg = tx.Msg
+			known.Confirmed = true
+			tx = known
+			if _, err := trans.Update(tx); err != nil {
+				return err
 			}
-			tx := new(ModelTx)
-			tx.NewFromString(result)
+		} else {
 			tx.ReceivedTime = block.Time
-			if idx == 0 {
-				tx.IsCoinbase = true
-			} else {
-				tx.IsCoinbase = false
-			}
+			tx.IsCoinbase = idx == 0
 			tx.Confirmed = true
-			err := tx.InsertIntoDb(trans)
-			if err != nil {
-				log.Error(err.Error())
+			if err := tx.InsertIntoDb(trans); err != nil {
+				return err
 			}
+		}
 
-			//Maintain the relationship between block and tx
-			r := new(RelationBlockTx)
-			r.InsertIntoDb(trans, block, tx)
-			block.Extracted = true
-			trans.Update(block)
+		//Maintain the relationship between block and tx
+		r := new(RelationBlockTx)
+		r.InsertIntoDb(trans, block, tx)
+
+		if extract && !tx.Extracted {
+			if err := tx.InsertInOutIntoDb(trans); err != nil {
+				return err
+			}
 		}
-		trans.Commit()
 	}
+	block.Extracted = true
+	_, err := trans.Update(block)
+	return err
 }
 
 func extractTx(dbmap *gorp.DbMap) {
@@ -151,14 +339,16 @@ func extractTx(dbmap *gorp.DbMap) {
 		if err != nil {
 			break
 		}
-		resp := RpcGetrawtransaction(tx.Hash)
-		result, ok := resp["result"].(string)
-		tx1 := new(ModelTx)
-		if ok {
-			tx.UpdateInOutFromString(result)
-		} else {
-			log.Error("Type assert error. tx.Hash:%s.", tx1.Hash)
-			continue
+		result, err := RpcGetrawtransaction(tx.Hash.String())
+		if err != nil {
+			log.Error("Get tx failed. tx.Hash:%s, %s.", tx.Hash, err.Error())
+			trans.Rollback()
+			break
+		}
+		if err := tx.UpdateInOutFromString(result); err != nil {
+			log.Error("Decode tx failed. tx.Hash:%s, %s.", tx.Hash, err.Error())
+			trans.Rollback()
+			break
 		}
 
 		ins := new(ModelTxinSet)
@@ -181,7 +371,74 @@ func extractTx(dbmap *gorp.DbMap) {
 	}
 }
 
-func extractTxout(dbmap *gorp.DbMap) {
+// extractTxBulk extracts txs with multi-row inserts until the blocks left
+// are within Bulk_tip_distance of the tip. The deferred indexes are rebuilt
+// before it returns, an error means some of them are missing.
+func extractTxBulk(dbmap *gorp.DbMap) error {
+	if err := DropDeferredIndexes(dbmap); err != nil {
+		return err
+	}
+	for {
+		height, err := UnextractedHeight(dbmap)
+		if err != nil {
+			log.Error(err.Error())
+			break
+		}
+		if height < 0 || !farFromTip(height) {
+			break
+		}
+		trans, _ := dbmap.Begin()
+		txs, err := NewTxsFromUnextracted(trans, bulkTxsPerCommit)
+		if err != nil || len(txs) == 0 {
+			trans.Rollback()
+			break
+		}
+		var txids []string
+		for _, tx := range txs {
+			txids = append(txids, tx.Hash.String())
+		}
+		results, err := RpcGetrawtransactions(txids)
+		if err != nil {
+			log.Error(err.Error())
+			trans.Rollback()
+			break
+		}
+		ins := new(ModelTxinSet)
+		outs := new(ModelTxoutSet)
+		for idx, tx := range txs {
+			if err = tx.UpdateInOutFromString(results[idx]); err != nil {
+				log.Error("Decode tx failed. tx.Hash:%s, %s.", tx.Hash, err.Error())
+				break
+			}
+			ins.NewFromTx(tx)
+			outs.NewFromTx(tx)
+		}
+		if err != nil {
+			trans.Rollback()
+			break
+		}
+		if err := MarkTxsExtracted(trans, txs); err != nil {
+			log.Error(err.Error())
+			trans.Rollback()
+			break
+		}
+		if err := ins.BulkInsertIntoDb(trans); err != nil {
+			log.Error(err.Error())
+			trans.Rollback()
+			break
+		}
+		if err := outs.BulkInsertIntoDb(trans); err != nil {
+			log.Error(err.Error())
+			trans.Rollback()
+			break
+		}
+		trans.Commit()
+		log.Info("Bulk extracted %d txs, %d txins, %d txouts.", len(txs), len(ins.TxInSet), len(outs.TxOutSet))
+	}
+	return RebuildDeferredIndexes(dbmap)
+}
+
+func extractTxout(dbmap *gorp.DbMap, queries *Queries, cache *UtxoCache) {
 	for {
 		trans, _ := dbmap.Begin()
 		txout := new(ModelTxout)
@@ -190,17 +447,25 @@ func extractTxout(dbmap *gorp.DbMap) {
 			break
 		}
 
-		class, addresses, reqSig, _ := btcscript.ExtractPkScriptAddrs(txout.OutScript, &btcnet.MainNetParams)
+		class, addresses, reqSig := ExtractAddresses(txout.OutScript)
 		txout.Type = class
 		txout.ReqSig = reqSig
 
+		entry := &UtxoEntry{TxoutId: txout.Id, Value: txout.Value, Type: txout.Type}
+		outTx, err := trans.Get(ModelTx{}, txout.TxId)
+		if err != nil || outTx == nil {
+			log.Error("Tx of txout not found. Id:%d, TxId:%d.", txout.Id, txout.TxId)
+			trans.Rollback()
+			break
+		}
 		for _, address := range addresses {
 			mAddress := new(ModelAddress)
-			mAddress.UpdateFromDbByAddress(trans, address.EncodeAddress())
+			mAddress.UpdateFromDbByAddress(trans, queries, address)
 			trans.Update(mAddress)
 			r := new(RelationTxoutAddress)
 			r.InsertIntoDb(trans, txout, mAddress)
-			if txout.Type >= btcscript.PubKeyTy && txout.Type <= btcscript.ScriptHashTy {
+			entry.AddressIds = append(entry.AddressIds, mAddress.Id)
+			if CountsToBalance(txout.Type) {
 				mAddress.Balance += txout.Value
 				trans.Update(mAddress)
 			}
@@ -208,55 +473,36 @@ func extractTxout(dbmap *gorp.DbMap) {
 		txout.Extracted = true
 		trans.Update(txout)
 		trans.Commit()
+		if !txout.Spent {
+			cache.Add(Outpoint{Hash: outTx.(*ModelTx).Hash, Index: txout.OutIndex}, entry)
+		}
 	}
 }
 
-func extractTxin(dbmap *gorp.DbMap) {
+func extractTxin(dbmap *gorp.DbMap, cache *UtxoCache) {
+	var lastId int64
 	for {
-		trans, _ := dbmap.Begin()
-		txin := new(ModelTxin)
-		err := txin.NewFromUncalculated(trans)
-		if err != nil {
+		txins, err := NewTxinsFromUncalculated(dbmap, lastId, txinsPerBatch)
+		if err != nil || len(txins) == 0 {
 			break
 		}
-		if txin.IsCoinbase {
-			log.Info("Txin is from coinbase,skip.")
-			txin.Calculated = true
-			trans.Update(txin)
-			trans.Commit()
-			continue
-		}
-		var txout = new(ModelTxout)
-		query := fmt.Sprintf("select * from txout where OutTxHash=\"%s\" and OutIndex=%d", txin.PrevOutHash, txin.PrevOutIndex)
-		err = trans.SelectOne(txout, query)
-		if err != nil {
-			log.Error(err.Error())
-		}
-		if txout.Id == 0 {
-			log.Info("No matched txout found.")
-			txin.Calculated = true
-			trans.Update(txin)
-			trans.Commit()
-			continue
-		} else {
-			log.Info("Txout matched, Id:%d, Hash:%s, Index:%d.", txout.Id, txout.OutTxHash, txout.OutIndex)
-			txin.Calculated = true
-			trans.Update(txin)
-			if txout.Type >= btcscript.PubKeyTy && txout.Type <= btcscript.ScriptHashTy {
-				address := new(ModelAddress)
-				err := trans.SelectOne(&address, "select * from address where Id in (select AddressId from txoutaddress where TxoutId=?)", txout.Id)
-				if err != nil {
+		for _, txin := range txins {
+			lastId = txin.Id
+			if err := cache.Spend(txin); err != nil {
+				log.Error(err.Error())
+				return
+			}
+			if cache.NeedFlush() {
+				if err := cache.Flush(dbmap); err != nil {
 					log.Error(err.Error())
+					return
 				}
-				address.Balance -= txout.Value
-				trans.Update(address)
 			}
-			txout.Spent = true
-			txout.RefTxinId = txin.Id
-			trans.Update(txout)
-			trans.Commit()
 		}
 	}
+	if err := cache.Flush(dbmap); err != nil {
+		log.Error(err.Error())
+	}
 }
 
 func checkBlock(dbmap *gorp.DbMap) {
@@ -273,7 +519,7 @@ func checkBlock(dbmap *gorp.DbMap) {
 		if len(block) == 0 {
 			continue
 		}
-		log.Debug(block[0].Hash)
+		log.Debug(block[0].Hash.String())
 	}
 	return
 }
@@ -282,11 +528,14 @@ func checkTx(dbmap *gorp.DbMap) {
 	txId, _ := GetMaxTxIdFromDB(dbmap)
 	var i int64
 	for i = 1; i < txId; i++ {
-		txHash, err := dbmap.SelectStr("select Hash from tx where Id=?", i)
+		tx := new(ModelTx)
+		err := dbmap.SelectOne(tx, "select * from tx where Id=?", i)
 		if err != nil {
 			log.Error(err.Error())
 			continue
 		}
-		RpcGetrawtransaction(txHash)
+		if _, err := RpcGetrawtransaction(tx.Hash.String()); err != nil {
+			log.Error("Get tx failed. tx.Hash:%s, %s.", tx.Hash, err.Error())
+		}
 	}
 }
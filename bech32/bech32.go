@@ -0,0 +1,148 @@
+// Package bech32 encodes and decodes segwit addresses, bech32 (BIP173) for
+// witness version 0 and bech32m (BIP350) for later versions.
+package bech32
+
+import (
+	"errors"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Checksum constants of bech32 and bech32m.
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+var ErrInvalidAddress = errors.New("Invalid segwit address.")
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	values := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return values
+}
+
+func checksumConst(version byte) uint32 {
+	if version == 0 {
+		return bech32Const
+	}
+	return bech32mConst
+}
+
+// convertBits regroups data of from bits per byte into to bits per byte. pad
+// completes the last group with zeros, otherwise leftover bits must be zero.
+func convertBits(data []byte, from uint, to uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxv := uint32(1)<<to - 1
+	maxAcc := uint32(1)<<(from+to-1) - 1
+	var out []byte
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, ErrInvalidAddress
+		}
+		acc = (acc<<from | uint32(v)) & maxAcc
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, ErrInvalidAddress
+	}
+	return out, nil
+}
+
+func validProgram(version byte, program []byte) bool {
+	if version > 16 || len(program) < 2 || len(program) > 40 {
+		return false
+	}
+	return version != 0 || len(program) == 20 || len(program) == 32
+}
+
+// EncodeSegwit returns the address of a witness program for the human
+// readable part hrp, "bc" on mainnet.
+func EncodeSegwit(hrp string, version byte, program []byte) (string, error) {
+	if !validProgram(version, program) {
+		return "", ErrInvalidAddress
+	}
+	data, err := convertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	data = append([]byte{version}, data...)
+	values := append(hrpExpand(hrp), data...)
+	mod := polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ checksumConst(version)
+	for i := 0; i < 6; i++ {
+		data = append(data, byte(mod>>uint(5*(5-i))&31))
+	}
+	addr := make([]byte, 0, len(hrp)+1+len(data))
+	addr = append(addr, hrp...)
+	addr = append(addr, '1')
+	for _, d := range data {
+		addr = append(addr, charset[d])
+	}
+	return string(addr), nil
+}
+
+// DecodeSegwit returns the witness version and program of an address with
+// the human readable part hrp. Addresses are all lower or all upper case.
+func DecodeSegwit(hrp string, addr string) (byte, []byte, error) {
+	if len(addr) > 90 {
+		return 0, nil, ErrInvalidAddress
+	}
+	lower := strings.ToLower(addr)
+	if addr != lower && addr != strings.ToUpper(addr) {
+		return 0, nil, ErrInvalidAddress
+	}
+	sep := strings.LastIndex(lower, "1")
+	//A version and the 6 checksum characters follow the separator.
+	if sep < 1 || sep+8 > len(lower) || lower[:sep] != hrp {
+		return 0, nil, ErrInvalidAddress
+	}
+	data := make([]byte, 0, len(lower)-sep-1)
+	for i := sep + 1; i < len(lower); i++ {
+		d := strings.IndexByte(charset, lower[i])
+		if d < 0 {
+			return 0, nil, ErrInvalidAddress
+		}
+		data = append(data, byte(d))
+	}
+	version := data[0]
+	if polymod(append(hrpExpand(hrp), data...)) != checksumConst(version) {
+		return 0, nil, ErrInvalidAddress
+	}
+	program, err := convertBits(data[1:len(data)-6], 5, 8, false)
+	if err != nil || !validProgram(version, program) {
+		return 0, nil, ErrInvalidAddress
+	}
+	return version, program, nil
+}
@@ -0,0 +1,73 @@
+package bech32
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Vectors of BIP173 and BIP350, scripts as version and program.
+var segwitVectors = []struct {
+	hrp     string
+	addr    string
+	version byte
+	program string
+}{
+	{"bc", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", 0, "751e76e8199196d454941c45d1b3a323f1433bd6"},
+	{"tb", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", 0, "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+	{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", 1, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+}
+
+func TestEncodeSegwit01(t *testing.T) {
+	for _, v := range segwitVectors {
+		program, _ := hex.DecodeString(v.program)
+		addr, err := EncodeSegwit(v.hrp, v.version, program)
+		if err != nil || addr != v.addr {
+			t.Errorf("Encode %s: got %s %v.", v.addr, addr, err)
+		}
+	}
+}
+
+func TestDecodeSegwit01(t *testing.T) {
+	for _, v := range segwitVectors {
+		version, program, err := DecodeSegwit(v.hrp, v.addr)
+		if err != nil || version != v.version || hex.EncodeToString(program) != v.program {
+			t.Errorf("Decode %s: got %d %x %v.", v.addr, version, program, err)
+		}
+	}
+}
+
+func TestEncodeSegwitInvalid01(t *testing.T) {
+	for _, c := range []struct {
+		version byte
+		program []byte
+	}{
+		//Version 0 programs are 20 or 32 bytes.
+		{0, make([]byte, 21)},
+		{1, make([]byte, 41)},
+		{17, make([]byte, 32)},
+	} {
+		if addr, err := EncodeSegwit("bc", c.version, c.program); err == nil {
+			t.Errorf("Encoded %s.", addr)
+		}
+	}
+}
+
+func TestDecodeSegwitInvalid01(t *testing.T) {
+	for _, c := range []struct{ hrp, addr string }{
+		//Wrong network
+		{"bc", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"},
+		//Bad checksum
+		{"bc", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5"},
+		//Mixed case
+		{"bc", "bc1Qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		//Version 1 with a bech32 instead of a bech32m checksum
+		{"bc", "bc1pqqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0sagmhkq"},
+		{"bc", "bc1zw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{"bc", "1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{"bc", ""},
+	} {
+		if _, _, err := DecodeSegwit(c.hrp, c.addr); err == nil {
+			t.Errorf("%s decoded.", c.addr)
+		}
+	}
+}
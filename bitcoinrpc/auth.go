@@ -0,0 +1,40 @@
+package bitcoinrpc
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+var ErrBadCookie = errors.New("RPC cookie file is not in user:password format.")
+
+// credentials returns the static user and password, or the content of the
+// bitcoind .cookie file when one is configured.
+func (n *rpcNode) credentials() (string, string, error) {
+	if n.cookieFile == "" {
+		return n.user, n.password, nil
+	}
+	n.cookieMutex.Lock()
+	defer n.cookieMutex.Unlock()
+	if n.cookieUser == "" {
+		content, err := ioutil.ReadFile(n.cookieFile)
+		if err != nil {
+			return "", "", err
+		}
+		parts := strings.SplitN(strings.TrimSpace(string(content)), ":", 2)
+		if len(parts) != 2 {
+			return "", "", ErrBadCookie
+		}
+		n.cookieUser, n.cookiePassword = parts[0], parts[1]
+		log.Info("RPC cookie of %s loaded from %s.", n.name, n.cookieFile)
+	}
+	return n.cookieUser, n.cookiePassword, nil
+}
+
+// forgetCookie makes the next call re-read the cookie file. bitcoind writes
+// a new cookie every time it starts.
+func (n *rpcNode) forgetCookie() {
+	n.cookieMutex.Lock()
+	defer n.cookieMutex.Unlock()
+	n.cookieUser, n.cookiePassword = "", ""
+}
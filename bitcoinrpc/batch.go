@@ -0,0 +1,121 @@
+package bitcoinrpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const defaultBatchSize = 100
+
+var batchSize = defaultBatchSize
+
+// BatchRequest is one call of a batch. After CallBatch, Err holds the error
+// of this call alone and Result has been filled on success.
+type BatchRequest struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Err    error
+}
+
+func NewBatchRequest(method string, params []interface{}, result interface{}) *BatchRequest {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return &BatchRequest{Method: method, Params: params, Result: result}
+}
+
+// CallBatch sends all requests in a single HTTP POST. The returned error is
+// only set when the batch as a whole failed.
+func CallBatch(reqs []*BatchRequest) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	return withRetry(fmt.Sprintf("batch of %d %s", len(reqs), reqs[0].Method), func() error {
+		return callBatch(reqs)
+	})
+}
+
+func callBatch(reqs []*BatchRequest) error {
+	payload := make([]*rpcRequest, len(reqs))
+	byId := make(map[int32]*BatchRequest, len(reqs))
+	for i, req := range reqs {
+		payload[i] = &rpcRequest{Method: req.Method, Params: req.Params, Id: getRequestId()}
+		byId[payload[i].Id] = req
+		req.Err = nil
+	}
+	body, status, err := post(timeout, payload)
+	if err != nil {
+		return err
+	}
+	var rpcResps []rpcResponse
+	if err := json.Unmarshal(body, &rpcResps); err != nil {
+		//A malformed batch is answered with a single error object.
+		var rpcResp rpcResponse
+		if json.Unmarshal(body, &rpcResp) == nil && rpcResp.Error != nil {
+			return rpcResp.Error
+		}
+		return badResponse(status, err)
+	}
+	for _, rpcResp := range rpcResps {
+		req, ok := byId[rpcResp.Id]
+		if !ok {
+			continue
+		}
+		delete(byId, rpcResp.Id)
+		if rpcResp.Error != nil {
+			req.Err = rpcResp.Error
+			continue
+		}
+		req.Err = decodeResult(rpcResp.Result, req.Result)
+	}
+	for id, req := range byId {
+		req.Err = fmt.Errorf("No response for request %d in batch.", id)
+	}
+	return nil
+}
+
+// RpcGetrawtransactions fetches txids in batches and returns the raw txs in
+// the same order. It fails if any of the txs can not be fetched.
+func RpcGetrawtransactions(txids []string) ([]string, error) {
+	raws := make([]string, len(txids))
+	for start := 0; start < len(txids); start += batchSize {
+		end := start + batchSize
+		if end > len(txids) {
+			end = len(txids)
+		}
+		var reqs []*BatchRequest
+		for i := start; i < end; i++ {
+			reqs = append(reqs, NewBatchRequest("getrawtransaction", []interface{}{txids[i]}, &raws[i]))
+		}
+		if err := CallBatch(reqs); err != nil {
+			return nil, err
+		}
+		for i, req := range reqs {
+			if req.Err != nil {
+				return nil, fmt.Errorf("getrawtransaction %s: %s", txids[start+i], req.Err.Error())
+			}
+		}
+	}
+	return raws, nil
+}
+
+// RpcEstimatesmartfees asks for all targets in one batch. Targets the node
+// can not estimate are nil.
+func RpcEstimatesmartfees(targets []int64) ([]*SmartFee, error) {
+	fees := make([]*SmartFee, len(targets))
+	var reqs []*BatchRequest
+	for i, target := range targets {
+		fees[i] = new(SmartFee)
+		reqs = append(reqs, NewBatchRequest("estimatesmartfee", []interface{}{target}, fees[i]))
+	}
+	if err := CallBatch(reqs); err != nil {
+		return nil, err
+	}
+	for i, req := range reqs {
+		if req.Err != nil || fees[i].FeeRate <= 0 {
+			fees[i] = nil
+		}
+	}
+	return fees, nil
+}
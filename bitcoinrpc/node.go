@@ -0,0 +1,225 @@
+package bitcoinrpc
+
+import (
+	"Assange/config"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRpcAuth = errors.New("RPC authentication failed, check rpc_user, rpc_password or rpc_cookie_file.")
+var ErrRpcCA = errors.New("No certificate found in rpc_tls_ca_file.")
+var ErrNoRpcNode = errors.New("No RPC node configured.")
+
+// rpcNode is one bitcoind backend.
+type rpcNode struct {
+	name      string
+	server    string
+	transport *http.Transport
+
+	user       string
+	password   string
+	cookieFile string
+
+	cookieMutex    sync.Mutex
+	cookieUser     string
+	cookiePassword string
+}
+
+var nodes []*rpcNode
+
+// active is the index of the node calls go to until it fails.
+var active int32
+
+func newRpcNode(conf config.RpcNode) (*rpcNode, error) {
+	n := &rpcNode{
+		user:       conf.User,
+		password:   conf.Password,
+		cookieFile: conf.Cookie_file,
+	}
+	scheme := "http"
+	if conf.Tls {
+		scheme = "https"
+	}
+	host := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
+	n.name = host
+	if conf.Socket != "" {
+		host = "localhost"
+		n.name = conf.Socket
+	}
+	n.server = fmt.Sprintf("%s://%s/", scheme, host)
+	if conf.Wallet != "" {
+		n.server += "wallet/" + url.PathEscape(conf.Wallet)
+	}
+
+	n.transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if conf.Proxy != "" {
+		proxy, err := url.Parse(conf.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		n.transport.Proxy = http.ProxyURL(proxy)
+	}
+	if conf.Socket != "" {
+		socket := conf.Socket
+		n.transport.Proxy = nil
+		n.transport.Dial = func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		}
+	}
+	if conf.Tls_ca_file != "" {
+		pem, err := ioutil.ReadFile(conf.Tls_ca_file)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrRpcCA
+		}
+		n.transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return n, nil
+}
+
+func currentNode() *rpcNode {
+	return nodes[int(atomic.LoadInt32(&active))%len(nodes)]
+}
+
+// failover moves on to the next node, unless another call already did.
+func failover(failed *rpcNode) {
+	if len(nodes) < 2 {
+		return
+	}
+	for i, n := range nodes {
+		if n != failed {
+			continue
+		}
+		next := (i + 1) % len(nodes)
+		if atomic.CompareAndSwapInt32(&active, int32(i), int32(next)) {
+			log.Warning("RPC node %s failed, switch to %s.", failed.name, nodes[next].name)
+		}
+		return
+	}
+}
+
+// post sends one JSON-RPC payload to the active node and returns the
+// response body. Transient failures switch to the next node.
+func post(callTimeout time.Duration, payload interface{}) ([]byte, int, error) {
+	if len(nodes) == 0 {
+		return nil, 0, ErrNoRpcNode
+	}
+	n := currentNode()
+	body, status, err := n.post(callTimeout, payload)
+	if isTransient(err) {
+		failover(n)
+	}
+	return body, status, err
+}
+
+// post sends one JSON-RPC payload to n. bitcoind reports RPC errors with
+// HTTP 500 or 404 and a JSON body, so those bodies are returned as well.
+func (n *rpcNode) post(callTimeout time.Duration, payload interface{}) ([]byte, int, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest("POST", n.server, bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	user, password, err := n.credentials()
+	if err != nil {
+		//The cookie is missing while bitcoind restarts.
+		return nil, 0, &transientError{err}
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	client := &http.Client{Transport: n.transport, Timeout: callTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, &transientError{err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, &transientError{err}
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		if n.cookieFile != "" {
+			//A restarted bitcoind has written a new cookie.
+			n.forgetCookie()
+			return nil, resp.StatusCode, &transientError{ErrRpcAuth}
+		}
+		return nil, resp.StatusCode, ErrRpcAuth
+	}
+	return body, resp.StatusCode, nil
+}
+
+// crossCheck makes RpcCheckBlockhash ask every node.
+var crossCheck bool
+
+var ErrBlockhashMismatch = errors.New("RPC nodes disagree on the block hash.")
+var ErrNotEnoughNodes = errors.New("Not enough RPC nodes confirm the block hash.")
+
+// callNode runs method on n alone, without retry or failover.
+func callNode(n *rpcNode, method string, params []interface{}, result interface{}) error {
+	body, status, err := n.post(timeout, &rpcRequest{Method: method, Params: params, Id: getRequestId()})
+	if err != nil {
+		return err
+	}
+	var rpcResp rpcResponse
+	if err := json.Unmarshal(body, &rpcResp); err != nil {
+		return badResponse(status, err)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	return decodeResult(rpcResp.Result, result)
+}
+
+// RpcCheckBlockhash asks every node for the hash at height when
+// rpc_cross_check is set. Nodes which have not reached height yet or are
+// down are skipped, the others must all agree with hash and at least two
+// of them must answer.
+func RpcCheckBlockhash(height int64, hash string) error {
+	if !crossCheck || len(nodes) < 2 {
+		return nil
+	}
+	confirmed := 0
+	for _, n := range nodes {
+		var nodeHash string
+		err := callNode(n, "getblockhash", []interface{}{height}, &nodeHash)
+		if err != nil {
+			if e, ok := err.(*RpcError); ok && e.Code == RpcErrInvalidParameter {
+				log.Info("RPC node %s has not reached height %d.", n.name, height)
+				continue
+			}
+			if isTransient(err) {
+				log.Warning("RPC node %s skipped in cross check: %s", n.name, err.Error())
+				continue
+			}
+			return err
+		}
+		if nodeHash != hash {
+			log.Error("RPC node %s has block %s at height %d, expected %s.", n.name, nodeHash, height, hash)
+			return ErrBlockhashMismatch
+		}
+		confirmed++
+	}
+	if confirmed < 2 {
+		return ErrNotEnoughNodes
+	}
+	return nil
+}
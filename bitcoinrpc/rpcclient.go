@@ -3,83 +3,260 @@ package bitcoinrpc
 import (
 	"Assange/config"
 	"Assange/logging"
-	//"encoding/hex"
+	"bytes"
 	"encoding/json"
 	"fmt"
-	//"io/ioutil"
 	"net/http"
-	"strings"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 5
+
+	retryBackoffMin = 500 * time.Millisecond
+	retryBackoffMax = 30 * time.Second
 )
 
 var log = logging.GetLogger("RPC", logging.DEBUG)
-var server string
 var request_id int32
+var timeout time.Duration
+var retries int
+
+// InitRpcClient sets up the nodes of Rpc_nodes, or a single node from the
+// top level Rpc_* settings when the list is empty.
+func InitRpcClient(conf config.Configuration) error {
+	nodeConfs := conf.Rpc_nodes
+	if len(nodeConfs) == 0 {
+		nodeConfs = []config.RpcNode{{
+			Host:        conf.Rpc_host,
+			Port:        conf.Rpc_port,
+			User:        conf.Rpc_user,
+			Password:    conf.Rpc_password,
+			Cookie_file: conf.Rpc_cookie_file,
+			Tls:         conf.Rpc_tls,
+			Tls_ca_file: conf.Rpc_tls_ca_file,
+			Wallet:      conf.Rpc_wallet,
+			Socket:      conf.Rpc_socket,
+			Proxy:       conf.Rpc_proxy,
+		}}
+	}
+	nodes = nil
+	for _, nodeConf := range nodeConfs {
+		n, err := newRpcNode(nodeConf)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, n)
+	}
+	atomic.StoreInt32(&active, 0)
+	crossCheck = conf.Rpc_cross_check
 
-func InitRpcClient(config config.Configuration) {
-	server = fmt.Sprintf("http://%s:%s@%s:%d", config.Rpc_user,
-		config.Rpc_password,
-		config.Rpc_host,
-		config.Rpc_port)
-	request_id = 0
+	atomic.StoreInt32(&request_id, 0)
+	timeout = time.Duration(conf.Rpc_timeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	retries = conf.Rpc_retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	batchSize = conf.Rpc_batch_size
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	return nil
 }
 
 func getRequestId() int32 {
-	request_id += 1
-	return request_id
+	return atomic.AddInt32(&request_id, 1)
+}
+
+type rpcRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Id     int32         `json:"id"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RpcError       `json:"error"`
+	Id     int32           `json:"id"`
 }
 
-func BitcoinRPC(method string, params []interface{}) map[string]interface{} {
-	data, err := json.Marshal(map[string]interface{}{
-		"method": method,
-		"id":     getRequestId(),
-		"params": params,
+// transientError marks failures which are worth retrying.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *transientError:
+		return true
+	case *RpcError:
+		return e.Code == RpcErrInWarmup
+	}
+	return false
+}
+
+// Call runs method with the configured timeout and decodes the result into
+// result, which may be nil. Transient failures are retried with backoff.
+func Call(method string, params []interface{}, result interface{}) error {
+	return CallWithTimeout(timeout, method, params, result)
+}
+
+func CallWithTimeout(callTimeout time.Duration, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return withRetry(method, func() error {
+		return call(callTimeout, method, params, result)
 	})
-	if err != nil {
-		log.Error(err.Error())
+}
+
+func withRetry(what string, fn func() error) error {
+	backoff := retryBackoffMin
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			log.Warning("RPC %s failed: %s. Retry %d/%d in %s.", what, err.Error(), attempt, retries, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > retryBackoffMax {
+				backoff = retryBackoffMax
+			}
+		}
+		err = fn()
+		if err == nil || !isTransient(err) {
+			break
+		}
 	}
-	resp, err := http.Post(server, "application/json", strings.NewReader(string(data)))
-	if err != nil {
-		log.Error(err.Error())
+	if t, ok := err.(*transientError); ok {
+		return t.err
+	}
+	return err
+}
+
+func badResponse(status int, err error) error {
+	if status >= http.StatusInternalServerError {
+		return &transientError{fmt.Errorf("HTTP %d from bitcoind", status)}
+	}
+	return fmt.Errorf("Invalid RPC response, HTTP %d: %s", status, err.Error())
+}
+
+func decodeResult(raw json.RawMessage, result interface{}) error {
+	if result == nil {
+		return nil
 	}
-	defer resp.Body.Close()
-	d := json.NewDecoder(resp.Body)
+	d := json.NewDecoder(bytes.NewReader(raw))
 	d.UseNumber()
-	var x interface{}
-	if err := d.Decode(&x); err != nil {
-		log.Error(err.Error())
+	return d.Decode(result)
+}
+
+func call(callTimeout time.Duration, method string, params []interface{}, result interface{}) error {
+	body, status, err := post(callTimeout, &rpcRequest{Method: method, Params: params, Id: getRequestId()})
+	if err != nil {
+		return err
+	}
+	var rpcResp rpcResponse
+	if err := json.Unmarshal(body, &rpcResp); err != nil {
+		return badResponse(status, err)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
 	}
-	return x.(map[string]interface{})
+	return decodeResult(rpcResp.Result, result)
 }
 
-func RpcGetinfo() map[string]interface{} {
-	return BitcoinRPC("getinfo", []interface{}{})
+func RpcGetblockchaininfo() (*BlockchainInfo, error) {
+	info := new(BlockchainInfo)
+	if err := Call("getblockchaininfo", nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
-func RpcGetblockhash(index int64) map[string]interface{} {
-	return BitcoinRPC("getblockhash", []interface{}{index})
+func RpcGetblockhash(index int64) (string, error) {
+	var hash string
+	err := Call("getblockhash", []interface{}{index}, &hash)
+	return hash, err
 }
 
-func RpcGetblock(hash string) map[string]interface{} {
-	return BitcoinRPC("getblock", []interface{}{hash})
+func RpcGetblock(hash string) (*Block, error) {
+	block := new(Block)
+	if err := Call("getblock", []interface{}{hash}, block); err != nil {
+		return nil, err
+	}
+	return block, nil
 }
 
-func RpcGetblockcount() map[string]interface{} {
-	return BitcoinRPC("getblockcount", []interface{}{})
+// RpcGetblockVerbose needs bitcoind 0.15 or later.
+func RpcGetblockVerbose(hash string) (*BlockVerbose, error) {
+	block := new(BlockVerbose)
+	if err := Call("getblock", []interface{}{hash, 2}, block); err != nil {
+		return nil, err
+	}
+	return block, nil
 }
 
-func RpcGetrawtransaction(txid string) map[string]interface{} {
-	return BitcoinRPC("getrawtransaction", []interface{}{txid})
+func RpcGetblockcount() (int64, error) {
+	var count int64
+	err := Call("getblockcount", nil, &count)
+	return count, err
 }
 
-func RpcGetblockTxns(hash string) []string {
-	var ret []string
-	resp := RpcGetblock(hash)
-	result := resp["result"].(map[string]interface{})
-	txns, ok := result["tx"].([]interface{})
-	if ok {
-		for _, txnHash := range txns {
-			ret = append(ret, txnHash.(string))
+// RpcGetrawtransaction returns the serialized tx as hex.
+func RpcGetrawtransaction(txid string) (string, error) {
+	var raw string
+	err := Call("getrawtransaction", []interface{}{txid}, &raw)
+	return raw, err
+}
+
+// RpcGetrawmempoolOrdered returns the txids in bitcoind's mempool, every tx
+// after the unconfirmed txs it spends.
+func RpcGetrawmempoolOrdered() ([]string, error) {
+	entries := make(map[string]*MempoolEntry)
+	if err := Call("getrawmempool", []interface{}{true}, &entries); err != nil {
+		return nil, err
+	}
+	return mempoolOrder(entries), nil
+}
+
+func mempoolOrder(entries map[string]*MempoolEntry) []string {
+	txids := make([]string, 0, len(entries))
+	for txid := range entries {
+		txids = append(txids, txid)
+	}
+	sort.Strings(txids)
+	ordered := make([]string, 0, len(entries))
+	visited := make(map[string]bool, len(entries))
+	var visit func(txid string)
+	visit = func(txid string) {
+		entry, ok := entries[txid]
+		if !ok || visited[txid] {
+			return
+		}
+		visited[txid] = true
+		for _, parent := range entry.Depends {
+			visit(parent)
 		}
+		ordered = append(ordered, txid)
 	}
-	return ret
+	for _, txid := range txids {
+		visit(txid)
+	}
+	return ordered
+}
+
+// RpcSendrawtransaction relays a serialized tx and returns its txid.
+func RpcSendrawtransaction(raw string) (string, error) {
+	var txid string
+	err := Call("sendrawtransaction", []interface{}{raw}, &txid)
+	return txid, err
 }
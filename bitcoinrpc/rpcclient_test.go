@@ -0,0 +1,216 @@
+package bitcoinrpc
+
+import (
+	"Assange/config"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func testNode(url string) *rpcNode {
+	return &rpcNode{name: url, server: url, transport: &http.Transport{}}
+}
+
+func startServer(handler http.HandlerFunc) *httptest.Server {
+	ts := httptest.NewServer(handler)
+	nodes = []*rpcNode{testNode(ts.URL)}
+	active = 0
+	crossCheck = false
+	timeout = time.Second
+	retries = 2
+	return ts
+}
+
+func TestCallRpcError01(t *testing.T) {
+	ts := startServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"result":null,"error":{"code":-5,"message":"No information available about transaction"},"id":1}`)
+	})
+	defer ts.Close()
+
+	_, err := RpcGetrawtransaction("00")
+	rpcErr, ok := err.(*RpcError)
+	if !ok {
+		t.Fatalf("Expected *RpcError, got %v.", err)
+	}
+	if rpcErr.Code != RpcErrInvalidAddressOrKey {
+		t.Errorf("Code is %d, expected %d.", rpcErr.Code, RpcErrInvalidAddressOrKey)
+	}
+}
+
+func TestCallRetry01(t *testing.T) {
+	calls := 0
+	ts := startServer(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"result":"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f","error":null,"id":2}`)
+	})
+	defer ts.Close()
+
+	hash, err := RpcGetblockhash(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f" {
+		t.Errorf("Unexpected hash %s.", hash)
+	}
+	if calls != 2 {
+		t.Errorf("Server called %d times, expected 2.", calls)
+	}
+}
+
+func TestCallNodeDown01(t *testing.T) {
+	ts := startServer(func(w http.ResponseWriter, r *http.Request) {})
+	ts.Close()
+	retries = 0
+
+	if _, err := RpcGetblockcount(); err == nil {
+		t.Error("Expected an error while the node is down.")
+	}
+}
+
+func TestCallBatch01(t *testing.T) {
+	ts := startServer(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil || len(reqs) != 2 {
+			t.Errorf("Expected a batch of 2 requests, got %d, %v.", len(reqs), err)
+			return
+		}
+		//Answer out of order, the second call fails.
+		fmt.Fprintf(w, `[{"result":null,"error":{"code":-5,"message":"No such tx"},"id":%d},{"result":"0100","error":null,"id":%d}]`, reqs[1].Id, reqs[0].Id)
+	})
+	defer ts.Close()
+
+	var first, second string
+	reqs := []*BatchRequest{
+		NewBatchRequest("getrawtransaction", []interface{}{"aa"}, &first),
+		NewBatchRequest("getrawtransaction", []interface{}{"bb"}, &second),
+	}
+	if err := CallBatch(reqs); err != nil {
+		t.Fatal(err)
+	}
+	if reqs[0].Err != nil || first != "0100" {
+		t.Errorf("First call: %v, %s.", reqs[0].Err, first)
+	}
+	if rpcErr, ok := reqs[1].Err.(*RpcError); !ok || rpcErr.Code != RpcErrInvalidAddressOrKey {
+		t.Errorf("Second call: expected RPC error -5, got %v.", reqs[1].Err)
+	}
+}
+
+func TestCookieReread01(t *testing.T) {
+	f, err := ioutil.TempFile("", "cookie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	ioutil.WriteFile(f.Name(), []byte("__cookie__:old"), 0600)
+
+	ts := startServer(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "__cookie__" || password != "new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"result":7,"error":null,"id":1}`)
+	})
+	defer ts.Close()
+	nodes[0].cookieFile = f.Name()
+
+	//Load the old cookie, then simulate a bitcoind restart.
+	if _, _, err := nodes[0].credentials(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(f.Name(), []byte("__cookie__:new"), 0600)
+
+	count, err := RpcGetblockcount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("Block count is %d, expected 7.", count)
+	}
+}
+
+func TestFailover01(t *testing.T) {
+	down := startServer(func(w http.ResponseWriter, r *http.Request) {})
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":9,"error":null,"id":1}`)
+	}))
+	defer up.Close()
+	nodes = append(nodes, testNode(up.URL))
+
+	count, err := RpcGetblockcount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 9 {
+		t.Errorf("Block count is %d, expected 9.", count)
+	}
+	if currentNode() != nodes[1] {
+		t.Error("Expected the second node to be active.")
+	}
+}
+
+func TestCheckBlockhash01(t *testing.T) {
+	hashServer := func(result string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, result)
+		}))
+	}
+	a := hashServer(`{"result":"aa","error":null,"id":1}`)
+	defer a.Close()
+	b := hashServer(`{"result":"aa","error":null,"id":1}`)
+	defer b.Close()
+	behind := hashServer(`{"result":null,"error":{"code":-8,"message":"Block height out of range"},"id":1}`)
+	defer behind.Close()
+	forked := hashServer(`{"result":"bb","error":null,"id":1}`)
+	defer forked.Close()
+	timeout = time.Second
+	crossCheck = true
+	defer func() { crossCheck = false }()
+
+	nodes = []*rpcNode{testNode(a.URL), testNode(behind.URL), testNode(b.URL)}
+	if err := RpcCheckBlockhash(1, "aa"); err != nil {
+		t.Errorf("Expected agreement, got %v.", err)
+	}
+	nodes = []*rpcNode{testNode(a.URL), testNode(behind.URL)}
+	if err := RpcCheckBlockhash(1, "aa"); err != ErrNotEnoughNodes {
+		t.Errorf("Expected ErrNotEnoughNodes, got %v.", err)
+	}
+	nodes = []*rpcNode{testNode(a.URL), testNode(forked.URL)}
+	if err := RpcCheckBlockhash(1, "aa"); err != ErrBlockhashMismatch {
+		t.Errorf("Expected ErrBlockhashMismatch, got %v.", err)
+	}
+}
+
+func TestWalletPath01(t *testing.T) {
+	n, err := newRpcNode(config.RpcNode{Host: "127.0.0.1", Port: 8332, Wallet: "my wallet+1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.server != "http://127.0.0.1:8332/wallet/my%20wallet+1" {
+		t.Errorf("Unexpected endpoint %s.", n.server)
+	}
+}
+
+func TestMempoolOrder01(t *testing.T) {
+	entries := map[string]*MempoolEntry{
+		"aa": {Depends: []string{"cc"}},
+		"bb": {},
+		"cc": {Depends: []string{"dd", "gone"}},
+		"dd": {},
+	}
+	order := mempoolOrder(entries)
+	if fmt.Sprint(order) != "[dd cc aa bb]" {
+		t.Errorf("Unexpected order %v.", order)
+	}
+}
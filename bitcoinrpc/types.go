@@ -0,0 +1,87 @@
+package bitcoinrpc
+
+import (
+	"fmt"
+)
+
+// Error codes from bitcoind's rpcprotocol.h.
+const (
+	RpcErrMiscError            = -1
+	RpcErrTypeError            = -3
+	RpcErrInvalidAddressOrKey  = -5
+	RpcErrInvalidParameter     = -8
+	RpcErrDatabaseError        = -20
+	RpcErrDeserialization      = -22
+	RpcErrVerify               = -25
+	RpcErrVerifyRejected       = -26
+	RpcErrVerifyAlreadyInChain = -27
+	RpcErrInWarmup             = -28
+	RpcErrMethodNotFound       = -32601
+)
+
+// RpcError is the error object of a JSON-RPC response.
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// Block is the result of getblock with the default verbosity.
+type Block struct {
+	Hash              string   `json:"hash"`
+	Confirmations     int64    `json:"confirmations"`
+	Size              int64    `json:"size"`
+	Weight            int64    `json:"weight"`
+	Height            int64    `json:"height"`
+	Version           int32    `json:"version"`
+	MerkleRoot        string   `json:"merkleroot"`
+	Tx                []string `json:"tx"`
+	Time              int64    `json:"time"`
+	Nonce             uint32   `json:"nonce"`
+	Bits              string   `json:"bits"`
+	Difficulty        float64  `json:"difficulty"`
+	PreviousBlockHash string   `json:"previousblockhash"`
+	NextBlockHash     string   `json:"nextblockhash"`
+}
+
+// BlockVerbose is the result of getblock with verbosity 2, every tx carries
+// its serialized hex so no getrawtransaction (and no -txindex) is needed.
+type BlockVerbose struct {
+	Block
+	Tx []BlockTx `json:"tx"`
+}
+
+type BlockTx struct {
+	Txid string `json:"txid"`
+	Hash string `json:"hash"`
+	Hex  string `json:"hex"`
+}
+
+// MempoolEntry is a tx of getrawmempool with verbose set, Depends lists
+// its unconfirmed parents.
+type MempoolEntry struct {
+	Size    int64    `json:"size"`
+	Time    int64    `json:"time"`
+	Depends []string `json:"depends"`
+}
+
+type BlockchainInfo struct {
+	Chain                string  `json:"chain"`
+	Blocks               int64   `json:"blocks"`
+	Headers              int64   `json:"headers"`
+	BestBlockHash        string  `json:"bestblockhash"`
+	Difficulty           float64 `json:"difficulty"`
+	VerificationProgress float64 `json:"verificationprogress"`
+	ChainWork            string  `json:"chainwork"`
+}
+
+// SmartFee is the result of estimatesmartfee, FeeRate is in BTC/kB and 0
+// when the node has no estimate.
+type SmartFee struct {
+	FeeRate float64  `json:"feerate"`
+	Errors  []string `json:"errors"`
+	Blocks  int64    `json:"blocks"`
+}
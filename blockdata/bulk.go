@@ -0,0 +1,115 @@
+package blockdata
+
+import (
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+)
+
+const (
+	//Rows per multi-row INSERT, keeps statements well below max_allowed_packet.
+	bulkInsertRows = 500
+
+	mysqlErrCantDropKey = 1091
+)
+
+type deferredIndex struct {
+	Table   string
+	Name    string
+	Columns string
+	Unique  bool
+}
+
+// Secondary indexes on txin and txout are not read while transactions are
+// extracted, so a bulk sync drops them and rebuilds them once at the end.
+var deferredIndexes = []deferredIndex{
+	{"txout", "uidx_txout_txid_outindex", "TxId,OutIndex", true},
+	{"txout", "idx_txout_extraced", "Extracted", false},
+	{"txout", "idx_txout_spent", "Spent", false},
+	{"txin", "idx_txin_prevouthash_prevoutindex", "PrevOutHash,PrevOutIndex", false},
+	{"txin", "idx_txin_txid", "TxId", false},
+	{"txin", "idx_txin_prevtxoutid", "PrevTxoutId", false},
+	{"txin", "idx_txin_calculated", "Calculated", false},
+}
+
+func DropDeferredIndexes(dbmap *gorp.DbMap) error {
+	for _, idx := range deferredIndexes {
+		_, err := dbmap.Exec(fmt.Sprintf("drop index %s on %s", idx.Name, idx.Table))
+		if err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlErrCantDropKey {
+				continue
+			}
+			return err
+		}
+		log.Info("Index %s dropped for bulk sync.", idx.Name)
+	}
+	return nil
+}
+
+// RebuildDeferredIndexes is safe to call repeatedly, e.g. after a bulk sync was interrupted.
+func RebuildDeferredIndexes(dbmap *gorp.DbMap) error {
+	for _, idx := range deferredIndexes {
+		unique := ""
+		if idx.Unique {
+			unique = "unique "
+		}
+		log.Info("Rebuilding index %s.", idx.Name)
+		_, err := dbmap.Exec(fmt.Sprintf("create %sindex %s on %s(%s)", unique, idx.Name, idx.Table, idx.Columns))
+		if err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlErrDupKeyName {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// UnextractedHeight returns the lowest height of a block with txs left to
+// extract, -1 if there is none.
+func UnextractedHeight(exec gorp.SqlExecutor) (int64, error) {
+	height, err := exec.SelectNullInt(`select min(block.Height) from tx
+		join blocktx on blocktx.TxId=tx.Id join block on block.Id=blocktx.BlockId
+		where tx.Extracted=0`)
+	if err != nil || !height.Valid {
+		return -1, err
+	}
+	return height.Int64, nil
+}
+
+// MarkTxsExtracted flags txs as extracted with one statement per batch.
+func MarkTxsExtracted(trans *gorp.Transaction, txs []*ModelTx) error {
+	if len(txs) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.Id
+		tx.Extracted = true
+	}
+	_, err := trans.Exec("update tx set Extracted=1 where Id in ("+placeholders(len(ids))+")", ids...)
+	return err
+}
+
+// bulkInsert writes rows with multi-row INSERT statements. Ids are not read back.
+func bulkInsert(trans *gorp.Transaction, table string, columns []string, rows [][]interface{}) error {
+	placeholder := "(" + placeholders(len(columns)) + ")"
+	for start := 0; start < len(rows); start += bulkInsertRows {
+		end := start + bulkInsertRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			values = append(values, placeholder)
+			args = append(args, row...)
+		}
+		query := fmt.Sprintf("insert into %s (%s) values %s", table, strings.Join(columns, ","), strings.Join(values, ","))
+		if _, err := trans.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
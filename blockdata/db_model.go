@@ -36,12 +36,16 @@ func InitDb(config config.Configuration) (*gorp.DbMap, error) {
 	return &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}, nil
 }
 
+// InitTables registers the table mappings. The tables themselves are created by MigrateDb.
 func InitTables(dbmap *gorp.DbMap) {
+	InitModelSchemaVersionTable(dbmap)
 	InitModelBlockTable(dbmap)
 	InitModelTxTable(dbmap)
+	InitModelScriptTable(dbmap)
 	InitModelTxoutTable(dbmap)
 	InitModelTxinTable(dbmap)
 	InitModelAddress(dbmap)
+	InitModelWebhookTables(dbmap)
 }
 
 func GetMaxBlockHeightFromDB(dbmap *gorp.DbMap) (int64, error) {
@@ -0,0 +1,87 @@
+package blockdata
+
+import (
+	"github.com/coopernurse/gorp"
+	"sync/atomic"
+)
+
+// indexVersion counts the changes of the indexed tip and mempool, fee
+// statistics are cached until it moves.
+var indexVersion int64
+
+// IndexChanged is called by the indexer after every change.
+func IndexChanged() {
+	atomic.AddInt64(&indexVersion, 1)
+}
+
+func IndexVersion() int64 {
+	return atomic.LoadInt64(&indexVersion)
+}
+
+// TxFee is the size and fee of one tx, the input for fee rate statistics.
+type TxFee struct {
+	TxId int64
+	Size int64
+	Fee  int64
+}
+
+func (f *TxFee) Rate() float64 {
+	return float64(f.Fee) / float64(f.Size)
+}
+
+// Fees are only known once every input has been matched to its output, txs
+// with unresolved inputs or an unknown size are skipped.
+const txFeeSelect = `select tx.Id as TxId, tx.Size as Size,
+	(select coalesce(sum(prev.Value), 0) from txin join txout prev on prev.Id=txin.PrevTxoutId where txin.TxId=tx.Id) -
+	(select coalesce(sum(txout.Value), 0) from txout where txout.TxId=tx.Id) as Fee
+	from tx `
+
+const txFeeResolved = ` and tx.IsCoinbase=0 and tx.Size>0
+	and not exists (select 1 from txin where txin.TxId=tx.Id and txin.PrevTxoutId=0)`
+
+// TxFeesOfBlock returns the fees of the non coinbase txs of a block.
+func TxFeesOfBlock(exec gorp.SqlExecutor, blockId int64) ([]*TxFee, error) {
+	var fees []*TxFee
+	_, err := exec.Select(&fees, txFeeSelect+"join blocktx on blocktx.TxId=tx.Id where blocktx.BlockId=?"+txFeeResolved, blockId)
+	return fees, err
+}
+
+// TxFeesOfMempool returns the fees of the unconfirmed txs.
+func TxFeesOfMempool(exec gorp.SqlExecutor) ([]*TxFee, error) {
+	var fees []*TxFee
+	_, err := exec.Select(&fees, txFeeSelect+"where tx.Confirmed=0"+txFeeResolved)
+	return fees, err
+}
+
+// BlockStats sums up the txs of a block. Input values count once the
+// inputs are resolved, Unresolved tells how many are not.
+type BlockStats struct {
+	TxCount    int64
+	TotalOut   int64
+	TotalIn    int64
+	NonCbOut   int64
+	Unresolved int64
+}
+
+// Fee is the total fee of the block, known when all inputs are resolved.
+func (s *BlockStats) Fee() (int64, bool) {
+	if s.Unresolved > 0 {
+		return 0, false
+	}
+	return s.TotalIn - s.NonCbOut, true
+}
+
+func BlockStatsOf(exec gorp.SqlExecutor, blockId int64) (*BlockStats, error) {
+	stats := new(BlockStats)
+	err := exec.SelectOne(stats, `select
+	(select count(*) from blocktx where BlockId=?) as TxCount,
+	(select coalesce(sum(txout.Value), 0) from txout join blocktx on blocktx.TxId=txout.TxId where blocktx.BlockId=?) as TotalOut,
+	(select coalesce(sum(prev.Value), 0) from txin join blocktx on blocktx.TxId=txin.TxId join txout prev on prev.Id=txin.PrevTxoutId where blocktx.BlockId=? and txin.IsCoinbase=0) as TotalIn,
+	(select coalesce(sum(txout.Value), 0) from txout join blocktx on blocktx.TxId=txout.TxId where blocktx.BlockId=? and txout.IsCoinbase=0) as NonCbOut,
+	(select count(*) from txin join blocktx on blocktx.TxId=txin.TxId where blocktx.BlockId=? and txin.IsCoinbase=0 and txin.PrevTxoutId=0) as Unresolved`,
+		blockId, blockId, blockId, blockId, blockId)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,70 @@
+package blockdata
+
+import (
+	. "Assange/util"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/conformal/btcwire"
+)
+
+// Hash is a block or tx hash stored as BINARY(32). Bytes are kept in the
+// order bitcoind displays them, so UNHEX of the old hex columns converts
+// directly and prefixes sort the same way as their hex strings.
+type Hash [BytesPerBlockHash]byte
+
+var ErrHashLength = errors.New("Hash must be 32 bytes.")
+
+func NewHashFromStr(s string) (Hash, error) {
+	var h Hash
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != BytesPerBlockHash {
+		return h, ErrHashLength
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// NewHashFromSha converts from btcwire's internal byte order.
+func NewHashFromSha(sha *btcwire.ShaHash) Hash {
+	var h Hash
+	copy(h[:], ReverseBytes(sha[:]))
+	return h
+}
+
+func (h Hash) ShaHash() *btcwire.ShaHash {
+	var sha btcwire.ShaHash
+	copy(sha[:], ReverseBytes(h[:]))
+	return &sha
+}
+
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+func (h Hash) Value() (driver.Value, error) {
+	return h[:], nil
+}
+
+func (h *Hash) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*h = Hash{}
+	case []byte:
+		if len(v) != BytesPerBlockHash {
+			return ErrHashLength
+		}
+		copy(h[:], v)
+	default:
+		return fmt.Errorf("Can not scan %T into Hash.", src)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package blockdata
+
+import (
+	"testing"
+)
+
+func TestHashFromStr01(t *testing.T) {
+	hashHex := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	hash, err := NewHashFromStr(hashHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash.String() != hashHex {
+		t.Errorf("Hash is %s, expected %s.", hash.String(), hashHex)
+	}
+	if hash.ShaHash()[0] != 0x6f {
+		t.Error("ShaHash is not in internal byte order.")
+	}
+	if NewHashFromSha(hash.ShaHash()) != hash {
+		t.Error("Hash does not survive a round trip through ShaHash.")
+	}
+}
+
+func TestHashScan01(t *testing.T) {
+	var hash Hash
+	if err := hash.Scan([]byte{1, 2, 3}); err == nil {
+		t.Error("Short value scanned without error.")
+	}
+	if err := hash.Scan(nil); err != nil || !hash.IsZero() {
+		t.Error("NULL does not scan into a zero hash.")
+	}
+}
+
+func TestHashPrefixRange01(t *testing.T) {
+	low, high, err := HashPrefixRange("00000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if low.String() != "0000000000100000000000000000000000000000000000000000000000000000" {
+		t.Errorf("Low end is %s.", low)
+	}
+	if high.String() != "00000000001fffffffffffffffffffffffffffffffffffffffffffffffffffff" {
+		t.Errorf("High end is %s.", high)
+	}
+	for _, prefix := range []string{"", "xyz", "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f0"} {
+		if _, _, err := HashPrefixRange(prefix); err == nil {
+			t.Errorf("Expected an error for prefix %q.", prefix)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package blockdata
+
+import (
+	"github.com/conformal/btcscript"
+	"github.com/coopernurse/gorp"
+)
+
+// UnconfirmedTxs returns all txs not yet seen in a block.
+func UnconfirmedTxs(exec gorp.SqlExecutor) ([]*ModelTx, error) {
+	var txs []*ModelTx
+	_, err := exec.Select(&txs, "select * from tx where Confirmed=0")
+	return txs, err
+}
+
+// adjustBalance moves the balance of every address of a standard txout,
+// the same outputs extractTxout and UtxoCache account for.
+func adjustBalance(trans *gorp.Transaction, txoutId int64, class btcscript.ScriptClass, delta int64) error {
+	if !CountsToBalance(class) {
+		return nil
+	}
+	_, err := trans.Exec("update address join txoutaddress on txoutaddress.AddressId=address.Id set address.Balance=address.Balance+? where txoutaddress.TxoutId=?", delta, txoutId)
+	return err
+}
+
+// DeleteUnconfirmedFromDb removes a tx which left the mempool without being
+// mined, undoing its effect on spent flags and balances. Txs spending its
+// outputs get their inputs unresolved again.
+func (tx *ModelTx) DeleteUnconfirmedFromDb(trans *gorp.Transaction) error {
+	var ins []*ModelTxin
+	if _, err := trans.Select(&ins, "select * from txin where TxId=?", tx.Id); err != nil {
+		return err
+	}
+	for _, in := range ins {
+		if in.PrevTxoutId == 0 {
+			continue
+		}
+		prev := new(ModelTxout)
+		if err := trans.SelectOne(prev, "select * from txout where Id=?", in.PrevTxoutId); err != nil {
+			continue
+		}
+		if _, err := trans.Exec("update txout set Spent=0, RefTxinId=0 where Id=?", prev.Id); err != nil {
+			return err
+		}
+		if err := adjustBalance(trans, prev.Id, prev.Type, prev.Value); err != nil {
+			return err
+		}
+	}
+
+	var outs []*ModelTxout
+	if _, err := trans.Select(&outs, "select * from txout where TxId=?", tx.Id); err != nil {
+		return err
+	}
+	for _, out := range outs {
+		var delta int64
+		if out.Extracted {
+			delta -= out.Value
+		}
+		if out.Spent {
+			delta += out.Value
+			if _, err := trans.Exec("update txin set PrevTxoutId=0, Calculated=0 where Id=?", out.RefTxinId); err != nil {
+				return err
+			}
+		}
+		if err := adjustBalance(trans, out.Id, out.Type, delta); err != nil {
+			return err
+		}
+		if _, err := trans.Exec("delete from txoutaddress where TxoutId=?", out.Id); err != nil {
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		"delete from txout where TxId=?",
+		"delete from txin where TxId=?",
+		"delete from tx where Id=? and Confirmed=0",
+	} {
+		if _, err := trans.Exec(query, tx.Id); err != nil {
+			return err
+		}
+	}
+	log.Info("Unconfirmed tx removed, Id:%d, Hash:%s.", tx.Id, tx.Hash)
+	return nil
+}
@@ -0,0 +1,449 @@
+package blockdata
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"time"
+)
+
+// MySQL error numbers tolerated while applying migrations.
+const (
+	mysqlErrDupFieldName = 1060
+	mysqlErrDupKeyName   = 1061
+)
+
+var (
+	ErrSchemaOutdated = errors.New("Database schema is older than this binary, run with -migrate.")
+	ErrSchemaTooNew   = errors.New("Database schema is newer than this binary.")
+)
+
+type Migration struct {
+	Version     int64
+	Description string
+	Up          []Step
+}
+
+// Step is one statement of a migration. It is skipped when Unless, a query
+// answering a count, returns non-zero, which guards statements that must not
+// run twice.
+type Step struct {
+	Sql    string
+	Unless string
+}
+
+func statements(stmts ...string) []Step {
+	steps := make([]Step, len(stmts))
+	for i, stmt := range stmts {
+		steps[i] = Step{Sql: stmt}
+	}
+	return steps
+}
+
+func columnCount(count string, table string, column string, cond string) string {
+	return fmt.Sprintf("select %s from information_schema.columns where table_schema=database() and table_name='%s' and column_name='%s'%s", count, table, column, cond)
+}
+
+func columnExists(table string, column string) string {
+	return columnCount("count(*)", table, column, "")
+}
+
+func columnMissing(table string, column string) string {
+	return columnCount("count(*)=0", table, column, "")
+}
+
+func columnHasType(table string, column string, columnType string) string {
+	return columnCount("count(*)", table, column, " and column_type='"+columnType+"'")
+}
+
+type ModelSchemaVersion struct {
+	Version     int64
+	Description string
+	AppliedTime time.Time
+}
+
+// Migrations are applied in order and must never be edited once released.
+// Append a new entry for every schema change instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Baseline tables and indexes",
+		Up: statements(
+			`create table if not exists block (
+				Id bigint not null auto_increment,
+				Height bigint,
+				Hash varchar(255),
+				PrevHash varchar(255),
+				NextHash varchar(255),
+				MerkleRoot varchar(255),
+				Time datetime,
+				Ver int,
+				Nonce int unsigned,
+				Bits int unsigned,
+				Extracted boolean,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_block_hash on block(Hash)",
+			"create index idx_block_prevhash on block(PrevHash)",
+			"create index idx_block_nexthash on block(NextHash)",
+			"create index idx_block_height on block(Height)",
+			"create index idx_block_extracted on block(Extracted)",
+
+			`create table if not exists tx (
+				Id bigint not null auto_increment,
+				Hash varchar(255),
+				Ver int,
+				LockTime int unsigned,
+				ReceivedTime datetime,
+				IsCoinbase boolean,
+				Extracted boolean,
+				Confirmed boolean,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_tx_hash on tx(Hash)",
+			"create index idx_tx_extracted on tx(Extracted)",
+			"create index idx_tx_confirmed on tx(Confirmed)",
+
+			`create table if not exists blocktx (
+				Id bigint not null auto_increment,
+				BlockId bigint,
+				TxId bigint,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_blocktx_blockid_txid on blocktx(BlockId,TxId)",
+			"create index idx_blocktx_blockid on blocktx(BlockId)",
+			"create index idx_blocktx_txid on blocktx(TxId)",
+
+			`create table if not exists txout (
+				Id bigint not null auto_increment,
+				OutTxHash varchar(255),
+				OutScript mediumblob,
+				OutIndex bigint,
+				Value bigint,
+				Type tinyint unsigned,
+				ReqSig int,
+				IsCoinbase boolean,
+				Extracted boolean,
+				Spent boolean,
+				RefTxinId bigint,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_txout_outtxhash_outindex on txout(OutTxHash,OutIndex)",
+			"create index idx_txout_extraced on txout(Extracted)",
+			"create index idx_txout_spent on txout(Spent)",
+
+			`create table if not exists txin (
+				Id bigint not null auto_increment,
+				InTxHash varchar(255),
+				InScript mediumblob,
+				Sequence int unsigned,
+				PrevOutHash varchar(255),
+				PrevOutIndex bigint,
+				IsCoinbase boolean,
+				Calculated boolean,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create index idx_txin_prevouthash_prevoutindex on txin(PrevOutHash,PrevOutIndex)",
+			"create index idx_txin_intxhash on txin(InTxHash)",
+			"create index idx_txin_calculated on txin(Calculated)",
+
+			`create table if not exists address (
+				Id bigint not null auto_increment,
+				Address varchar(255),
+				Balance bigint,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_address_address on address(Address)",
+
+			`create table if not exists txoutaddress (
+				Id bigint not null auto_increment,
+				TxoutId bigint,
+				AddressId bigint,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index idx_txoutaddress_txoutid_addressid on txoutaddress(TxoutId,AddressId)",
+			"create index idx_txoutaddress_txoutid on txoutaddress(TxoutId)",
+			"create index idx_txoutaddress_addressid on txoutaddress(AddressId)",
+		),
+	},
+	{
+		Version:     2,
+		Description: "Binary hashes, integer foreign keys and shared output scripts",
+		//Every step can be repeated: columns are only filled while their
+		//source column exists, and hex is only converted while it is 64
+		//characters long.
+		Up: []Step{
+			//Output scripts are moved into their own table, keyed by sha256.
+			{Sql: `create table if not exists script (
+				Id bigint not null auto_increment,
+				Hash binary(32) not null,
+				Script mediumblob,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`},
+			{Sql: "create unique index uidx_script_hash on script(Hash)"},
+			{Sql: "insert ignore into script (Hash, Script) select unhex(sha2(OutScript, 256)), OutScript from txout",
+				Unless: columnMissing("txout", "OutScript")},
+
+			//Foreign keys are filled while the hashes are still hex.
+			{Sql: "alter table txout add column TxId bigint after Id, add column ScriptId bigint after Value",
+				Unless: columnExists("txout", "TxId")},
+			{Sql: "update txout join tx on tx.Hash=txout.OutTxHash set txout.TxId=tx.Id",
+				Unless: columnMissing("txout", "OutTxHash")},
+			{Sql: "update txout join script on script.Hash=unhex(sha2(txout.OutScript, 256)) set txout.ScriptId=script.Id",
+				Unless: columnMissing("txout", "OutScript")},
+			//A bulk sync may have dropped the index already.
+			{Sql: "drop index uidx_txout_outtxhash_outindex on txout"},
+			{Sql: "alter table txout drop column OutTxHash, drop column OutScript",
+				Unless: columnMissing("txout", "OutTxHash")},
+			{Sql: "create unique index uidx_txout_txid_outindex on txout(TxId,OutIndex)"},
+
+			{Sql: "alter table txin add column TxId bigint after Id, add column PrevTxoutId bigint after PrevOutIndex",
+				Unless: columnExists("txin", "TxId")},
+			{Sql: "update txin join tx on tx.Hash=txin.InTxHash set txin.TxId=tx.Id",
+				Unless: columnMissing("txin", "InTxHash")},
+			{Sql: "update txin join txout on txout.RefTxinId=txin.Id set txin.PrevTxoutId=txout.Id"},
+			{Sql: "update txin set PrevTxoutId=0 where PrevTxoutId is null"},
+			{Sql: "drop index idx_txin_intxhash on txin"},
+			{Sql: "alter table txin drop column InTxHash",
+				Unless: columnMissing("txin", "InTxHash")},
+			{Sql: "create index idx_txin_txid on txin(TxId)"},
+			{Sql: "create index idx_txin_prevtxoutid on txin(PrevTxoutId)"},
+
+			//Hex strings become BINARY(32) in display byte order.
+			{Sql: "drop index idx_txin_prevouthash_prevoutindex on txin"},
+			{Sql: "alter table txin modify PrevOutHash varbinary(64)",
+				Unless: columnHasType("txin", "PrevOutHash", "binary(32)")},
+			{Sql: "update txin set PrevOutHash=unhex(PrevOutHash) where length(PrevOutHash)=64"},
+			{Sql: "alter table txin modify PrevOutHash binary(32)"},
+			{Sql: "create index idx_txin_prevouthash_prevoutindex on txin(PrevOutHash,PrevOutIndex)"},
+
+			{Sql: "drop index uidx_tx_hash on tx"},
+			{Sql: "alter table tx modify Hash varbinary(64)",
+				Unless: columnHasType("tx", "Hash", "binary(32)")},
+			{Sql: "update tx set Hash=unhex(Hash) where length(Hash)=64"},
+			{Sql: "alter table tx modify Hash binary(32) not null"},
+			{Sql: "create unique index uidx_tx_hash on tx(Hash)"},
+
+			{Sql: "drop index uidx_block_hash on block"},
+			{Sql: "drop index idx_block_prevhash on block"},
+			{Sql: "drop index idx_block_nexthash on block"},
+			{Sql: "alter table block modify Hash varbinary(64), modify PrevHash varbinary(64), modify NextHash varbinary(64), modify MerkleRoot varbinary(64)",
+				Unless: columnHasType("block", "Hash", "binary(32)")},
+			{Sql: "update block set Hash=unhex(Hash) where length(Hash)=64"},
+			{Sql: "update block set PrevHash=unhex(PrevHash) where length(PrevHash)=64"},
+			{Sql: "update block set NextHash=unhex(NextHash) where length(NextHash)=64"},
+			{Sql: "update block set MerkleRoot=unhex(MerkleRoot) where length(MerkleRoot)=64"},
+			{Sql: "update block set PrevHash=null where length(PrevHash)=0"},
+			{Sql: "update block set NextHash=null where length(NextHash)=0"},
+			{Sql: "alter table block modify Hash binary(32) not null, modify PrevHash binary(32), modify NextHash binary(32), modify MerkleRoot binary(32)"},
+			{Sql: "create unique index uidx_block_hash on block(Hash)"},
+			{Sql: "create index idx_block_prevhash on block(PrevHash)"},
+			{Sql: "create index idx_block_nexthash on block(NextHash)"},
+		},
+	},
+	{
+		Version:     3,
+		Description: "Serialized tx size for fee rates",
+		Up: []Step{
+			//Txs indexed before have size 0 and are left out of fee statistics.
+			{Sql: "alter table tx add column Size int not null default 0 after LockTime",
+				Unless: columnExists("tx", "Size")},
+		},
+	},
+	{
+		Version:     4,
+		Description: "Block size and weight",
+		Up: []Step{
+			{Sql: "alter table block add column Size int not null default 0 after Bits, add column Weight int not null default 0 after Size",
+				Unless: columnExists("block", "Size")},
+		},
+	},
+	{
+		Version:     5,
+		Description: "Webhooks and their delivery outbox",
+		Up: statements(
+			`create table if not exists webhook (
+				Id bigint not null auto_increment,
+				PublicId char(32) not null,
+				Url varchar(2048) not null,
+				Secret char(64) not null,
+				Confirmations int not null default 0,
+				Active tinyint(1) not null default 1,
+				Created datetime,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_webhook_publicid on webhook(PublicId)",
+
+			//Value is an address or a txid in hex.
+			`create table if not exists webhookwatch (
+				Id bigint not null auto_increment,
+				WebhookId bigint not null,
+				Value varchar(64) not null,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_webhookwatch_webhookid_value on webhookwatch(WebhookId,Value)",
+			"create index idx_webhookwatch_value on webhookwatch(Value)",
+
+			//A tx is delivered to a webhook at most once.
+			`create table if not exists webhookdelivery (
+				Id bigint not null auto_increment,
+				WebhookId bigint not null,
+				TxHash binary(32) not null,
+				Payload mediumtext not null,
+				Status varchar(16) not null,
+				Attempts int not null default 0,
+				NextAttempt datetime,
+				LastError varchar(255) not null default '',
+				Created datetime,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create unique index uidx_webhookdelivery_webhookid_txhash on webhookdelivery(WebhookId,TxHash)",
+			"create index idx_webhookdelivery_status_nextattempt on webhookdelivery(Status,NextAttempt)",
+
+			`create table if not exists webhookattempt (
+				Id bigint not null auto_increment,
+				DeliveryId bigint not null,
+				Time datetime,
+				StatusCode int not null default 0,
+				Error varchar(255) not null default '',
+				Duration int not null default 0,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			"create index idx_webhookattempt_deliveryid on webhookattempt(DeliveryId)",
+		),
+	},
+	{
+		Version:     6,
+		Description: "How far the webhook outbox is filled",
+		Up: statements(
+			`create table if not exists webhookmark (
+				Id bigint not null,
+				BlockHeight bigint not null,
+				TxId bigint not null,
+				primary key (Id)
+			) engine=InnoDB charset=UTF8`,
+			//Queueing starts at the current index.
+			"insert ignore into webhookmark (Id, BlockHeight, TxId) select 1, coalesce(max(Height), -1), (select coalesce(max(Id), 0) from tx) from block",
+		),
+	},
+	{
+		Version:     7,
+		Description: "Webhook watches of any bech32 address length, with their kind",
+		Up: []Step{
+			{Sql: "alter table webhookwatch modify Value varchar(90) not null"},
+			{Sql: "alter table webhookwatch add column Kind varchar(8) not null default 'address' after WebhookId",
+				Unless: columnExists("webhookwatch", "Kind")},
+			//Only txids were stored as 64 hex digits so far.
+			{Sql: "update webhookwatch set Kind='txid' where Value regexp '^[0-9a-f]{64}$'"},
+		},
+	},
+}
+
+func LatestSchemaVersion() int64 {
+	return migrations[len(migrations)-1].Version
+}
+
+func InitModelSchemaVersionTable(dbmap *gorp.DbMap) {
+	dbmap.AddTableWithName(ModelSchemaVersion{}, "schema_version").SetKeys(false, "Version")
+}
+
+func createSchemaVersionTable(dbmap *gorp.DbMap) error {
+	_, err := dbmap.Exec(`create table if not exists schema_version (
+		Version bigint not null,
+		Description varchar(255),
+		AppliedTime datetime,
+		primary key (Version)
+	) engine=InnoDB charset=UTF8`)
+	return err
+}
+
+// GetSchemaVersion returns the highest applied migration, 0 for an empty database.
+func GetSchemaVersion(dbmap *gorp.DbMap) (int64, error) {
+	if err := createSchemaVersionTable(dbmap); err != nil {
+		return 0, err
+	}
+	version, err := dbmap.SelectNullInt("select max(Version) from schema_version")
+	if err != nil {
+		return 0, err
+	}
+	return version.Int64, nil
+}
+
+// CheckSchema refuses to run against a database which is not at LatestSchemaVersion.
+func CheckSchema(dbmap *gorp.DbMap) error {
+	version, err := GetSchemaVersion(dbmap)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if version < latest {
+		log.Error("Schema version is %d, expected %d.", version, latest)
+		return ErrSchemaOutdated
+	}
+	if version > latest {
+		log.Error("Schema version is %d, this binary supports up to %d.", version, latest)
+		return ErrSchemaTooNew
+	}
+	log.Info("Schema version is %d.", version)
+	return nil
+}
+
+// MigrateDb applies every pending migration in order.
+func MigrateDb(dbmap *gorp.DbMap) error {
+	version, err := GetSchemaVersion(dbmap)
+	if err != nil {
+		return err
+	}
+	if version > LatestSchemaVersion() {
+		return ErrSchemaTooNew
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := applyMigration(dbmap, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tolerated(number uint16) bool {
+	return number == mysqlErrDupFieldName || number == mysqlErrDupKeyName || number == mysqlErrCantDropKey
+}
+
+func applyMigration(dbmap *gorp.DbMap, m Migration) error {
+	log.Info("Applying migration %d: %s.", m.Version, m.Description)
+	//MySQL commits DDL implicitly, so statements have to be safe to re-run
+	//after a partial failure. Columns and indexes which are already added or
+	//dropped are therefore tolerated.
+	for _, step := range m.Up {
+		if step.Unless != "" {
+			done, err := dbmap.SelectInt(step.Unless)
+			if err != nil {
+				return fmt.Errorf("migration %d failed: %s", m.Version, err.Error())
+			}
+			if done != 0 {
+				continue
+			}
+		}
+		if _, err := dbmap.Exec(step.Sql); err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok && tolerated(mysqlErr.Number) {
+				log.Warning("Migration %d: %s", m.Version, err.Error())
+				continue
+			}
+			return fmt.Errorf("migration %d failed: %s", m.Version, err.Error())
+		}
+	}
+	applied := &ModelSchemaVersion{
+		Version:     m.Version,
+		Description: m.Description,
+		AppliedTime: time.Now(),
+	}
+	if err := dbmap.Insert(applied); err != nil {
+		return err
+	}
+	log.Info("Schema migrated to version %d.", m.Version)
+	return nil
+}
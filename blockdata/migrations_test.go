@@ -0,0 +1,37 @@
+package blockdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrationsOrdered01(t *testing.T) {
+	var last int64
+	for _, m := range migrations {
+		if m.Version <= last {
+			t.Errorf("Migration %d is not after %d.", m.Version, last)
+		}
+		if len(m.Up) == 0 {
+			t.Errorf("Migration %d has no statements.", m.Version)
+		}
+		last = m.Version
+	}
+	if last != LatestSchemaVersion() {
+		t.Errorf("Latest schema version is %d, expected %d.", LatestSchemaVersion(), last)
+	}
+}
+
+func TestMigrationsRerunnable01(t *testing.T) {
+	for _, m := range migrations {
+		for _, step := range m.Up {
+			//Adding a column fails and converting hex corrupts when repeated.
+			if strings.Contains(step.Sql, "add column") && step.Unless == "" {
+				t.Errorf("Migration %d adds a column unguarded: %s", m.Version, step.Sql)
+			}
+			if strings.HasPrefix(step.Sql, "update") && strings.Contains(step.Sql, "=unhex(") &&
+				!strings.Contains(step.Sql, "where length(") && step.Unless == "" {
+				t.Errorf("Migration %d converts hex unguarded: %s", m.Version, step.Sql)
+			}
+		}
+	}
+}
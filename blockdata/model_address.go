@@ -1,6 +1,7 @@
 package blockdata
 
 import (
+	"database/sql"
 	//. "Assange/util"
 	//"encoding/hex"
 	//"encoding/json"
@@ -32,20 +33,23 @@ func (a *ModelAddress) NewFromString(s string) {
 	a.Balance = 0
 }
 
-func (a *ModelAddress) UpdateFromDbByAddress(trans *gorp.Transaction, address string) {
-	addrBuff := new(ModelAddress)
-	trans.SelectOne(&addrBuff, "select * from address where Address=?", address)
-	if addrBuff.Id == 0 {
+// UpdateFromDbByAddress loads the address, inserting it when it is new.
+// Lookups see committed addresses only.
+func (a *ModelAddress) UpdateFromDbByAddress(trans *gorp.Transaction, queries *Queries, address string) {
+	addrBuff, err := queries.AddressByAddress(address)
+	switch err {
+	case nil:
+		a.Id = addrBuff.Id
+		a.Address = addrBuff.Address
+		a.Balance = addrBuff.Balance
+	case sql.ErrNoRows:
 		a.Address = address
 		a.Balance = 0
-		err := trans.Insert(a)
-		if err != nil {
+		if err := trans.Insert(a); err != nil {
 			log.Error(err.Error())
 		}
-	} else {
-		a.Id = addrBuff.Id
-		a.Address = addrBuff.Address
-		a.Balance = addrBuff.Balance
+	default:
+		log.Error(err.Error())
 	}
 }
 
@@ -57,17 +61,5 @@ func (r *RelationTxoutAddress) InsertIntoDb(trans *gorp.Transaction, txout *Mode
 
 func InitModelAddress(dbmap *gorp.DbMap) {
 	dbmap.AddTableWithName(ModelAddress{}, "address").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
-	}
-	dbmap.Exec("create unique index uidx_address_address on address(Address)")
-
 	dbmap.AddTableWithName(RelationTxoutAddress{}, "txoutaddress").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
-	}
-	dbmap.Exec("create unique index idx_txoutaddress_txoutid_addressid on txoutaddress(TxoutId,AddressId)")
-	dbmap.Exec("create index idx_txoutaddress_txoutid on txoutaddress(TxoutId)")
-	dbmap.Exec("create index idx_txoutaddress_addressid on txoutaddress(AddressId)")
-
 }
@@ -1,16 +1,17 @@
 package blockdata
 
 import (
+	"Assange/bitcoinrpc"
 	//"Assange/config"
 	//. "Assange/util"
 	//"database/sql"
 	//"encoding/hex"
-	"encoding/json"
+	//"encoding/json"
 	//"github.com/conformal/btcutil"
 	//"errors"
 	//"github.com/conformal/btcscript"
 	//"github.com/conformal/btcutil"
-	//"github.com/conformal/btcwire"
+	"github.com/conformal/btcwire"
 	"github.com/coopernurse/gorp"
 	_ "github.com/go-sql-driver/mysql"
 	. "strconv"
@@ -22,14 +23,21 @@ type ModelBlock struct {
 
 	//Block info
 	Height     int64
-	Hash       string
-	PrevHash   string
-	NextHash   string
-	MerkleRoot string
+	Hash       Hash
+	PrevHash   Hash
+	NextHash   Hash
+	MerkleRoot Hash
 	Time       time.Time
 	Ver        int32
 	Nonce      uint32
 	Bits       uint32
+	//Serialized size with witness data
+	Size int64
+	//BIP141 weight, 4 times the size for blocks without witness data
+	Weight int64
+
+	//Script of the coinbase input, only set for decoded blocks
+	CoinbaseData []byte `db:"-"`
 
 	//Transactions
 	Txs []*ModelTx `db:"-"`
@@ -38,25 +46,78 @@ type ModelBlock struct {
 	Extracted bool
 }
 
-func (block *ModelBlock) InsertIntoDb(trans *gorp.Transaction) {
-	var prevBlock *ModelBlock
+// InsertIntoDb inserts the block after its committed parent. The
+// transaction must be rolled back on error.
+func (block *ModelBlock) InsertIntoDb(trans *gorp.Transaction, queries *Queries) error {
 	if block.Height != 0 {
-		err := trans.SelectOne(&prevBlock, "select * from block where Hash=?", block.PrevHash)
+		prevBlock, err := queries.BlockByHash(block.PrevHash)
 		if err != nil {
-			log.Error(err.Error())
-			return
+			return err
 		}
 		prevBlock.NextHash = block.Hash
-		trans.Update(prevBlock)
+		if _, err := trans.Update(prevBlock); err != nil {
+			return err
+		}
 	} else {
 		block.Extracted = true
 	}
-	err := trans.Insert(block)
-	if err != nil {
-		log.Error(err.Error())
-		return
+	if err := trans.Insert(block); err != nil {
+		return err
 	}
 	log.Info("Insert new block, Id:%d, Height:%d.", block.Id, block.Height)
+	return nil
+}
+
+// DeleteFromDb disconnects the indexed tip in a reorg. Its txs go back to
+// the mempool with their spends and balances, except the coinbase which is
+// deleted. Returns the txs back in the mempool and the outputs gone with the
+// coinbase. The transaction must be rolled back on error.
+func (block *ModelBlock) DeleteFromDb(trans *gorp.Transaction) ([]*ModelTx, []Outpoint, error) {
+	var txs []*ModelTx
+	if _, err := trans.Select(&txs, "select tx.* from tx join blocktx on blocktx.TxId=tx.Id where blocktx.BlockId=? order by blocktx.Id", block.Id); err != nil {
+		return nil, nil, err
+	}
+	if _, err := trans.Exec("delete from blocktx where BlockId=?", block.Id); err != nil {
+		return nil, nil, err
+	}
+	var returned []*ModelTx
+	var gone []Outpoint
+	for _, tx := range txs {
+		//A duplicate coinbase (BIP30) stays confirmed by its other block.
+		others, err := trans.SelectInt("select count(*) from blocktx where TxId=?", tx.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if others > 0 {
+			continue
+		}
+		if _, err := trans.Exec("update tx set Confirmed=0 where Id=?", tx.Id); err != nil {
+			return nil, nil, err
+		}
+		tx.Confirmed = false
+		if !tx.IsCoinbase {
+			returned = append(returned, tx)
+			continue
+		}
+		var outs []*ModelTxout
+		if _, err := trans.Select(&outs, "select * from txout where TxId=?", tx.Id); err != nil {
+			return nil, nil, err
+		}
+		for _, out := range outs {
+			gone = append(gone, Outpoint{Hash: tx.Hash, Index: out.OutIndex})
+		}
+		if err := tx.DeleteUnconfirmedFromDb(trans); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := trans.Exec("update block set NextHash=? where Hash=?", Hash{}, block.PrevHash); err != nil {
+		return nil, nil, err
+	}
+	if _, err := trans.Exec("delete from block where Id=?", block.Id); err != nil {
+		return nil, nil, err
+	}
+	log.Info("Block disconnected, Id:%d, Height:%d.", block.Id, block.Height)
+	return returned, gone, nil
 }
 
 func (block *ModelBlock) NewFromUnextracted(trans *gorp.Transaction) error {
@@ -69,28 +130,41 @@ func (block *ModelBlock) NewFromUnextracted(trans *gorp.Transaction) error {
 	return nil
 }
 
-func (block *ModelBlock) NewFromMap(resultMap map[string]interface{}) error {
-	block.Height, _ = ParseInt(string(resultMap["height"].(json.Number)), 10, 64)
-	block.Hash = resultMap["hash"].(string)
-	if _, ok := resultMap["previousblockhash"]; ok {
-		block.PrevHash = resultMap["previousblockhash"].(string)
-	}
-	if _, ok := resultMap["nextblockhash"]; ok {
-		block.NextHash = resultMap["nextblockhash"].(string)
-	}
-	block.MerkleRoot = resultMap["merkleroot"].(string)
-	timeUint64, _ := ParseInt(string(resultMap["time"].(json.Number)), 10, 64)
-	block.Time = time.Unix(timeUint64, 0)
-	verUint64, _ := ParseUint(string(resultMap["version"].(json.Number)), 10, 32)
-	block.Ver = int32(verUint64)
-	nonceUint64, _ := ParseUint(string(resultMap["nonce"].(json.Number)), 10, 32)
-	block.Nonce = uint32(nonceUint64)
-	bitsUint64, _ := ParseUint(resultMap["bits"].(string), 16, 32)
+func (block *ModelBlock) NewFromRpc(result *bitcoinrpc.Block) error {
+	var err error
+	block.Height = result.Height
+	if block.Hash, err = NewHashFromStr(result.Hash); err != nil {
+		return err
+	}
+	if result.PreviousBlockHash != "" {
+		if block.PrevHash, err = NewHashFromStr(result.PreviousBlockHash); err != nil {
+			return err
+		}
+	}
+	if result.NextBlockHash != "" {
+		if block.NextHash, err = NewHashFromStr(result.NextBlockHash); err != nil {
+			return err
+		}
+	}
+	if block.MerkleRoot, err = NewHashFromStr(result.MerkleRoot); err != nil {
+		return err
+	}
+	block.Time = time.Unix(result.Time, 0)
+	block.Ver = result.Version
+	block.Nonce = result.Nonce
+	bitsUint64, _ := ParseUint(result.Bits, 16, 32)
 	block.Bits = uint32(bitsUint64)
-	txsResult, _ := resultMap["tx"].([]interface{})
-	for idx, txResult := range txsResult {
+	block.Size = result.Size
+	block.Weight = result.Weight
+	//Nodes before 0.13 report no weight, they know no witness either.
+	if block.Weight == 0 {
+		block.Weight = result.Size * 4
+	}
+	for idx, txHash := range result.Tx {
 		tx := new(ModelTx)
-		tx.Hash = txResult.(string)
+		if tx.Hash, err = NewHashFromStr(txHash); err != nil {
+			return err
+		}
 		tx.ReceivedTime = block.Time
 		tx.Confirmed = true
 		tx.Extracted = false
@@ -104,6 +178,41 @@ func (block *ModelBlock) NewFromMap(resultMap map[string]interface{}) error {
 	return nil
 }
 
+// NewFromMsg fills block and its Txs from a decoded block message. The
+// message does not carry the height, so the caller passes it.
+func (block *ModelBlock) NewFromMsg(height int64, msg *btcwire.MsgBlock) error {
+	hash, err := msg.BlockSha()
+	if err != nil {
+		return err
+	}
+	block.Height = height
+	block.Hash = NewHashFromSha(&hash)
+	block.PrevHash = NewHashFromSha(&msg.Header.PrevBlock)
+	block.MerkleRoot = NewHashFromSha(&msg.Header.MerkleRoot)
+	block.Time = msg.Header.Timestamp
+	block.Ver = msg.Header.Version
+	block.Nonce = msg.Header.Nonce
+	block.Bits = msg.Header.Bits
+	//Messages carry no witness, NewBlockFromRaw sets the sizes of
+	//witness blocks.
+	block.Size = int64(msg.SerializeSize())
+	block.Weight = block.Size * 4
+	if len(msg.Transactions) > 0 && len(msg.Transactions[0].TxIn) > 0 {
+		block.CoinbaseData = msg.Transactions[0].TxIn[0].SignatureScript
+	}
+	for idx, msgTx := range msg.Transactions {
+		tx := new(ModelTx)
+		if err := tx.NewFromMsg(msgTx); err != nil {
+			return err
+		}
+		tx.ReceivedTime = block.Time
+		tx.Confirmed = true
+		tx.IsCoinbase = idx == 0
+		block.Txs = append(block.Txs, tx)
+	}
+	return nil
+}
+
 func (block *ModelBlock) NewFromRpcByHeight(height int64) {
 }
 
@@ -112,12 +221,4 @@ func (block *ModelBlock) NewFromRpcByHas() {
 
 func InitModelBlockTable(dbmap *gorp.DbMap) {
 	dbmap.AddTableWithName(ModelBlock{}, "block").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
-	}
-	dbmap.Exec("create unique index uidx_block_hash on block(Hash)")
-	dbmap.Exec("create index idx_block_prevhash on block(PrevHash)")
-	dbmap.Exec("create index idx_block_nexthash on block(NextHash)")
-	dbmap.Exec("create index idx_block_height on block(Height)")
-	dbmap.Exec("create index idx_block_extracted on block(Extracted)")
 }
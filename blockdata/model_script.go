@@ -0,0 +1,36 @@
+package blockdata
+
+import (
+	"crypto/sha256"
+	"github.com/coopernurse/gorp"
+)
+
+// ModelScript stores every distinct output script once, txouts refer to it by Id.
+type ModelScript struct {
+	Id     int64
+	Hash   []byte
+	Script []byte
+}
+
+// GetOrInsertScript returns the Id of script, inserting it if it is new.
+func GetOrInsertScript(exec gorp.SqlExecutor, script []byte) (int64, error) {
+	sum := sha256.Sum256(script)
+	result, err := exec.Exec("insert into script (Hash, Script) values (?, ?) on duplicate key update Id=last_insert_id(Id)", sum[:], script)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func GetScriptById(exec gorp.SqlExecutor, id int64) ([]byte, error) {
+	script := new(ModelScript)
+	err := exec.SelectOne(script, "select * from script where Id=?", id)
+	if err != nil {
+		return nil, err
+	}
+	return script.Script, nil
+}
+
+func InitModelScriptTable(dbmap *gorp.DbMap) {
+	dbmap.AddTableWithName(ModelScript{}, "script").SetKeys(true, "Id")
+}
@@ -8,7 +8,6 @@ import (
 	"encoding/hex"
 	//"encoding/json"
 	//"fmt"
-	"github.com/conformal/btcutil"
 	//	"errors"
 	//	"github.com/conformal/btcscript"
 	//	"github.com/conformal/btcutil"
@@ -23,9 +22,10 @@ type ModelTx struct {
 	Id int64
 
 	//Transaction info
-	Hash         string
+	Hash         Hash
 	Ver          int32
 	LockTime     uint32
+	Size         int64
 	ReceivedTime time.Time
 
 	//More flags to be added
@@ -34,6 +34,10 @@ type ModelTx struct {
 	Confirmed  bool
 
 	Msg *btcwire.MsgTx `db:"-"`
+
+	//Decoded inputs and outputs, see NewInOutFromMsg
+	Txins  []*ModelTxin  `db:"-"`
+	Txouts []*ModelTxout `db:"-"`
 }
 
 type RelationBlockTx struct {
@@ -71,44 +75,115 @@ func (tx *ModelTx) NewFromUnextracted(trans *gorp.Transaction) error {
 	return nil
 }
 
-func (tx *ModelTx) NewFromString(result string) {
-	bytesResult, _ := hex.DecodeString(result)
-	tx1, err := btcutil.NewTxFromBytes(bytesResult)
+func NewTxsFromUnextracted(trans *gorp.Transaction, limit int) ([]*ModelTx, error) {
+	var txs []*ModelTx
+	_, err := trans.Select(&txs, "select * from tx where Extracted=0 order by Id limit ?", limit)
 	if err != nil {
 		log.Error(err.Error())
+		return nil, err
+	}
+	return txs, nil
+}
+
+// NewFromString fills tx from the hex serialization getrawtransaction
+// returns. tx is left unchanged if it can not be decoded.
+func (tx *ModelTx) NewFromString(result string) error {
+	bytesResult, err := hex.DecodeString(result)
+	if err != nil {
+		return err
 	}
-	tx.Msg = tx1.MsgTx()
+	msg, size, err := DecodeMsgTx(bytesResult)
+	if err != nil {
+		return err
+	}
+	tx.Msg = msg
 	hash, _ := tx.Msg.TxSha()
-	tx.Hash = hash.String()
+	tx.Hash = NewHashFromSha(&hash)
 	tx.Ver = tx.Msg.Version
 	tx.LockTime = tx.Msg.LockTime
+	tx.Size = size.Vsize()
 	tx.Extracted = false
+	return nil
 }
 
-func (tx *ModelTx) UpdateInOutFromString(result string) {
-	bytesResult, _ := hex.DecodeString(result)
-	tx1, err := btcutil.NewTxFromBytes(bytesResult)
+// NewFromMsg fills tx from a decoded message, as received from a P2P peer.
+// Messages carry no witness, callers which decoded one set Size to the
+// virtual size of its WireSize, which fee rates are based on.
+func (tx *ModelTx) NewFromMsg(msg *btcwire.MsgTx) error {
+	hash, err := msg.TxSha()
 	if err != nil {
-		log.Error(err.Error())
+		return err
 	}
-	tx.Msg = tx1.MsgTx()
+	tx.Msg = msg
+	tx.Hash = NewHashFromSha(&hash)
+	tx.Ver = msg.Version
+	tx.LockTime = msg.LockTime
+	tx.Size = int64(msg.SerializeSize())
+	tx.Extracted = false
+	return nil
 }
 
-func InitModelTxTable(dbmap *gorp.DbMap) {
-	dbmap.AddTableWithName(ModelTx{}, "tx").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
+// NewInOutFromMsg decodes Txins and Txouts from Msg, with classified
+// output scripts.
+func (tx *ModelTx) NewInOutFromMsg() {
+	ins := new(ModelTxinSet)
+	ins.NewFromTx(tx)
+	tx.Txins = ins.TxInSet
+	outs := new(ModelTxoutSet)
+	outs.NewFromTx(tx)
+	tx.Txouts = outs.TxOutSet
+	for _, out := range tx.Txouts {
+		out.Classify()
 	}
-	dbmap.Exec("create unique index uidx_tx_hash on tx(Hash)")
-	dbmap.Exec("create index idx_tx_blockhash on tx(BlockHash)")
-	dbmap.Exec("create index idx_tx_extracted on tx(Extracted)")
-	dbmap.Exec("create index idx_tx_confirmed on tx(Confirmed)")
+}
 
-	dbmap.AddTableWithName(RelationBlockTx{}, "blocktx").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
+// InsertInOutIntoDb stores the inputs and outputs of a tx whose Msg is set
+// and marks it extracted.
+func (tx *ModelTx) InsertInOutIntoDb(trans *gorp.Transaction) error {
+	ins := new(ModelTxinSet)
+	ins.NewFromTx(tx)
+	if err := ins.InsertIntoDb(trans); err != nil {
+		return err
 	}
-	dbmap.Exec("create unique index uidx_blocktx_blockid_txid on blocktx(BlockId,TxId)")
-	dbmap.Exec("create index idx_blocktx_blockid on blocktx(BlockId)")
-	dbmap.Exec("create index idx_blocktx_txid on blocktx(TxId)")
+	outs := new(ModelTxoutSet)
+	outs.NewFromTx(tx)
+	if err := outs.InsertIntoDb(trans); err != nil {
+		return err
+	}
+	tx.Extracted = true
+	_, err := trans.Update(tx)
+	return err
+}
+
+// InsertUnconfirmedIntoDb stores a tx seen outside of a block, together
+// with its inputs and outputs.
+func (tx *ModelTx) InsertUnconfirmedIntoDb(trans *gorp.Transaction) error {
+	tx.ReceivedTime = time.Now()
+	tx.IsCoinbase = false
+	tx.Confirmed = false
+	if err := tx.InsertIntoDb(trans); err != nil {
+		return err
+	}
+	return tx.InsertInOutIntoDb(trans)
+}
+
+// UpdateInOutFromString decodes the inputs and outputs of a stored tx from
+// its hex serialization.
+func (tx *ModelTx) UpdateInOutFromString(result string) error {
+	bytesResult, err := hex.DecodeString(result)
+	if err != nil {
+		return err
+	}
+	msg, size, err := DecodeMsgTx(bytesResult)
+	if err != nil {
+		return err
+	}
+	tx.Msg = msg
+	tx.Size = size.Vsize()
+	return nil
+}
+
+func InitModelTxTable(dbmap *gorp.DbMap) {
+	dbmap.AddTableWithName(ModelTx{}, "tx").SetKeys(true, "Id")
+	dbmap.AddTableWithName(RelationBlockTx{}, "blocktx").SetKeys(true, "Id")
 }
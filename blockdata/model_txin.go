@@ -23,12 +23,15 @@ type ModelTxin struct {
 	Id int64
 
 	//Transaction input info
-	InTxHash     string
+	TxId         int64
 	InScript     []byte
 	Sequence     uint32
-	PrevOutHash  string
+	PrevOutHash  Hash
 	PrevOutIndex int64
 
+	//Spent output, 0 until the txin is calculated
+	PrevTxoutId int64
+
 	//More flags to be added
 	IsCoinbase bool
 	Calculated bool
@@ -38,16 +41,11 @@ type ModelTxinSet struct {
 	TxInSet []*ModelTxin
 }
 
-func (in *ModelTxin) NewFromMsg(msg_tx *btcwire.MsgTx, msg_txin *btcwire.TxIn) error {
-	hash, err := msg_tx.TxSha()
-	if err != nil {
-		log.Error(err.Error())
-		return err
-	}
-	in.InTxHash = hash.String()
+func (in *ModelTxin) NewFromMsg(tx *ModelTx, msg_txin *btcwire.TxIn) error {
+	in.TxId = tx.Id
 	in.InScript = msg_txin.SignatureScript
 	in.Sequence = msg_txin.Sequence
-	in.PrevOutHash = msg_txin.PreviousOutPoint.Hash.String()
+	in.PrevOutHash = NewHashFromSha(&msg_txin.PreviousOutPoint.Hash)
 	in.PrevOutIndex = int64(msg_txin.PreviousOutPoint.Index)
 	in.Calculated = false
 	return nil
@@ -59,10 +57,22 @@ func (in *ModelTxin) NewFromUncalculated(trans *gorp.Transaction) error {
 		log.Error(err.Error())
 		return err
 	}
-	log.Debug("Uncalculated txin found. Id:%d, TxHash:%s.", in.Id, in.InTxHash)
+	log.Debug("Uncalculated txin found. Id:%d, TxId:%d.", in.Id, in.TxId)
 	return nil
 }
 
+// NewTxinsFromUncalculated pages by Id, so txins whose spends are still
+// buffered in a UtxoCache are not returned twice.
+func NewTxinsFromUncalculated(exec gorp.SqlExecutor, afterId int64, limit int) ([]*ModelTxin, error) {
+	var ins []*ModelTxin
+	_, err := exec.Select(&ins, "select * from txin where Calculated=0 and Id>? order by Id limit ?", afterId, limit)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return ins, nil
+}
+
 func (in *ModelTxin) InsertIntoDb(trans *gorp.Transaction) error {
 	//Insert txin into database
 	err := trans.Insert(in)
@@ -77,7 +87,7 @@ func (in *ModelTxin) InsertIntoDb(trans *gorp.Transaction) error {
 func (ins *ModelTxinSet) NewFromTx(tx *ModelTx) {
 	for _, msg_txin := range tx.Msg.TxIn {
 		in := new(ModelTxin)
-		in.NewFromMsg(tx.Msg, msg_txin)
+		in.NewFromMsg(tx, msg_txin)
 		in.IsCoinbase = tx.IsCoinbase
 		ins.TxInSet = append(ins.TxInSet, in)
 	}
@@ -93,12 +103,15 @@ func (ins *ModelTxinSet) InsertIntoDb(trans *gorp.Transaction) error {
 	return nil
 }
 
+func (ins *ModelTxinSet) BulkInsertIntoDb(trans *gorp.Transaction) error {
+	columns := []string{"TxId", "InScript", "Sequence", "PrevOutHash", "PrevOutIndex", "PrevTxoutId", "IsCoinbase", "Calculated"}
+	var rows [][]interface{}
+	for _, in := range ins.TxInSet {
+		rows = append(rows, []interface{}{in.TxId, in.InScript, in.Sequence, in.PrevOutHash, in.PrevOutIndex, in.PrevTxoutId, in.IsCoinbase, in.Calculated})
+	}
+	return bulkInsert(trans, "txin", columns, rows)
+}
+
 func InitModelTxinTable(dbmap *gorp.DbMap) {
 	dbmap.AddTableWithName(ModelTxin{}, "txin").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
-	}
-	dbmap.Exec("create index idx_txin_preouthash_preoutindex on txin(PreOutHash,PreOutIndex)")
-	dbmap.Exec("create index idx_txin_intxhash on txin(InTxHash)")
-	dbmap.Exec("create index idx_txin_calculated on txin(Calculated)")
 }
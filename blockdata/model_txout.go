@@ -10,7 +10,6 @@ import (
 	//"github.com/conformal/btcutil"
 	//"errors"
 	"github.com/conformal/btcscript"
-	//"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
 	"github.com/coopernurse/gorp"
 	_ "github.com/go-sql-driver/mysql"
@@ -22,14 +21,16 @@ type ModelTxout struct {
 	Id int64
 
 	//Transaction output info
-	OutTxHash string
-	OutScript []byte
+	TxId      int64
 	OutIndex  int64
 	Value     int64
+	ScriptId  int64
+	OutScript []byte `db:"-"`
 
 	//Info extracted from script
-	Type   btcscript.ScriptClass
-	ReqSig int
+	Type      btcscript.ScriptClass
+	ReqSig    int
+	Addresses []string `db:"-"`
 
 	//More flags to be added
 	IsCoinbase bool
@@ -51,16 +52,21 @@ func (out *ModelTxout) NewFromUnextracted(trans *gorp.Transaction) error {
 		return err
 	}
 	log.Debug("Uncalculated txout found. Id:%d.", out.Id)
-	return nil
+	return out.LoadScript(trans)
 }
 
-func (out *ModelTxout) NewFromMsg(msg_tx *btcwire.MsgTx, msg_txout *btcwire.TxOut, idx_txout int64) error {
-	hash, err := msg_tx.TxSha()
+func (out *ModelTxout) LoadScript(exec gorp.SqlExecutor) error {
+	script, err := GetScriptById(exec, out.ScriptId)
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
-	out.OutTxHash = hash.String()
+	out.OutScript = script
+	return nil
+}
+
+func (out *ModelTxout) NewFromMsg(tx *ModelTx, msg_txout *btcwire.TxOut, idx_txout int64) error {
+	out.TxId = tx.Id
 	out.OutScript = msg_txout.PkScript
 	out.Value = msg_txout.Value
 	out.OutIndex = idx_txout
@@ -69,20 +75,31 @@ func (out *ModelTxout) NewFromMsg(msg_tx *btcwire.MsgTx, msg_txout *btcwire.TxOu
 	return nil
 }
 
+// Classify fills Type, ReqSig and Addresses from OutScript.
+func (out *ModelTxout) Classify() {
+	out.Type, out.Addresses, out.ReqSig = ExtractAddresses(out.OutScript)
+}
+
 func (out *ModelTxout) InsertIntoDb(trans *gorp.Transaction) error {
-	err := trans.Insert(out)
+	scriptId, err := GetOrInsertScript(trans, out.OutScript)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	out.ScriptId = scriptId
+	err = trans.Insert(out)
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
-	log.Debug("Insert new txout into DB. Output tx hash:%s, value:%d, index:%d.", out.OutTxHash, out.Value, out.OutIndex)
+	log.Debug("Insert new txout into DB. TxId:%d, value:%d, index:%d.", out.TxId, out.Value, out.OutIndex)
 	return nil
 }
 
 func (outs *ModelTxoutSet) NewFromTx(tx *ModelTx) {
 	for idx, msg_txout := range tx.Msg.TxOut {
 		out := new(ModelTxout)
-		out.NewFromMsg(tx.Msg, msg_txout, int64(idx))
+		out.NewFromMsg(tx, msg_txout, int64(idx))
 		out.IsCoinbase = tx.IsCoinbase
 		outs.TxOutSet = append(outs.TxOutSet, out)
 	}
@@ -98,12 +115,25 @@ func (outs *ModelTxoutSet) InsertIntoDb(trans *gorp.Transaction) error {
 	return nil
 }
 
+func (outs *ModelTxoutSet) BulkInsertIntoDb(trans *gorp.Transaction) error {
+	columns := []string{"TxId", "OutIndex", "Value", "ScriptId", "Type", "ReqSig", "IsCoinbase", "Extracted", "Spent", "RefTxinId"}
+	var rows [][]interface{}
+	scriptIds := make(map[string]int64)
+	for _, out := range outs.TxOutSet {
+		scriptId, ok := scriptIds[string(out.OutScript)]
+		if !ok {
+			var err error
+			if scriptId, err = GetOrInsertScript(trans, out.OutScript); err != nil {
+				return err
+			}
+			scriptIds[string(out.OutScript)] = scriptId
+		}
+		out.ScriptId = scriptId
+		rows = append(rows, []interface{}{out.TxId, out.OutIndex, out.Value, out.ScriptId, out.Type, out.ReqSig, out.IsCoinbase, out.Extracted, out.Spent, out.RefTxinId})
+	}
+	return bulkInsert(trans, "txout", columns, rows)
+}
+
 func InitModelTxoutTable(dbmap *gorp.DbMap) {
 	dbmap.AddTableWithName(ModelTxout{}, "txout").SetKeys(true, "Id")
-	if err := dbmap.CreateTablesIfNotExists(); err != nil {
-		log.Error(err.Error())
-	}
-	dbmap.Exec("create unique index uidx_txout_outtxhash_outindex on txout(OutTxHash,OutIndex)")
-	dbmap.Exec("create index idx_txout_extraced on txout(extracted)")
-	dbmap.Exec("create index idx_txout_spent on txout(spent)")
 }
@@ -0,0 +1,99 @@
+package blockdata
+
+import (
+	"Assange/bech32"
+	"errors"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"strings"
+)
+
+var ErrInvalidAddress = errors.New("Invalid address.")
+
+// The network addresses are encoded for, mainnet unless SetTestnet is
+// called at start. btcnet predates BIP34, so its activation height is kept
+// here.
+var (
+	netParams   = &btcnet.MainNetParams
+	segwitHrp   = "bc"
+	bip34Height = int64(227931)
+)
+
+func SetTestnet(testnet bool) {
+	if testnet {
+		netParams, segwitHrp, bip34Height = &btcnet.TestNet3Params, "tb", 21111
+	} else {
+		netParams, segwitHrp, bip34Height = &btcnet.MainNetParams, "bc", 227931
+	}
+}
+
+func NetParams() *btcnet.Params {
+	return netParams
+}
+
+// Bip34Height is the height from which blocks of the configured network
+// carry their height first in the coinbase script.
+func Bip34Height() int64 {
+	return bip34Height
+}
+
+// WitnessProgram splits a BIP141 output script into its version and
+// program.
+func WitnessProgram(script []byte) (byte, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 || int(script[1]) != len(script)-2 {
+		return 0, nil, false
+	}
+	switch {
+	case script[0] == btcscript.OP_0:
+		return 0, script[2:], true
+	case script[0] >= btcscript.OP_1 && script[0] <= btcscript.OP_16:
+		return script[0] - btcscript.OP_1 + 1, script[2:], true
+	}
+	return 0, nil, false
+}
+
+// ExtractAddresses classifies an output script and encodes the addresses it
+// pays to. btcscript predates segwit and calls witness programs
+// nonstandard, they get their bech32 or bech32m address here.
+func ExtractAddresses(script []byte) (btcscript.ScriptClass, []string, int) {
+	class, addresses, reqSig, _ := btcscript.ExtractPkScriptAddrs(script, netParams)
+	var encoded []string
+	for _, address := range addresses {
+		encoded = append(encoded, address.EncodeAddress())
+	}
+	if class == btcscript.NonStandardTy {
+		if version, program, ok := WitnessProgram(script); ok {
+			if address, err := bech32.EncodeSegwit(segwitHrp, version, program); err == nil {
+				encoded = append(encoded, address)
+				reqSig = 1
+			}
+		}
+	}
+	return class, encoded, reqSig
+}
+
+// ParseAddress checks that addr is an address of the configured network and
+// returns it the way the indexer stores it. Bech32 addresses come in lower
+// case and hex public keys as their P2PKH address.
+func ParseAddress(addr string) (string, error) {
+	if strings.HasPrefix(strings.ToLower(addr), segwitHrp+"1") {
+		version, program, err := bech32.DecodeSegwit(segwitHrp, addr)
+		if err != nil {
+			return "", ErrInvalidAddress
+		}
+		return bech32.EncodeSegwit(segwitHrp, version, program)
+	}
+	address, err := btcutil.DecodeAddress(addr, netParams)
+	if err != nil || address == nil || !address.IsForNet(netParams) {
+		return "", ErrInvalidAddress
+	}
+	return address.EncodeAddress(), nil
+}
+
+// CountsToBalance is true for the outputs whose value is added to the
+// balance of their address: single key, script hash and witness outputs.
+// Other nonstandard scripts have no address.
+func CountsToBalance(class btcscript.ScriptClass) bool {
+	return class <= btcscript.ScriptHashTy
+}
@@ -0,0 +1,55 @@
+package blockdata
+
+import (
+	"encoding/hex"
+	"github.com/conformal/btcscript"
+	"testing"
+)
+
+func TestExtractAddressesWitness01(t *testing.T) {
+	defer SetTestnet(false)
+	script, _ := hex.DecodeString("0014751e76e8199196d454941c45d1b3a323f1433bd6")
+	for _, c := range []struct {
+		testnet bool
+		address string
+	}{
+		{false, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{true, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+	} {
+		SetTestnet(c.testnet)
+		class, addresses, reqSig := ExtractAddresses(script)
+		if class != btcscript.NonStandardTy || len(addresses) != 1 || addresses[0] != c.address || reqSig != 1 {
+			t.Errorf("Testnet %v: got %v %v %d.", c.testnet, class, addresses, reqSig)
+		}
+	}
+}
+
+func TestExtractAddressesNonstandard01(t *testing.T) {
+	//OP_RETURN is not a witness program.
+	if _, addresses, _ := ExtractAddresses([]byte{0x6a, 0x02, 0xaa, 0xbb}); len(addresses) != 0 {
+		t.Errorf("Got addresses %v.", addresses)
+	}
+}
+
+func TestParseAddress01(t *testing.T) {
+	const p2wpkh = "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	for _, c := range []struct{ addr, parsed string }{
+		{p2wpkh, p2wpkh},
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", p2wpkh},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"},
+		{"12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S", "12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S"},
+	} {
+		if parsed, err := ParseAddress(c.addr); err != nil || parsed != c.parsed {
+			t.Errorf("%s: got %s %v.", c.addr, parsed, err)
+		}
+	}
+	for _, addr := range []string{
+		"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+		"",
+	} {
+		if _, err := ParseAddress(addr); err == nil {
+			t.Errorf("%s parsed.", addr)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package blockdata
+
+import (
+	"database/sql"
+)
+
+const (
+	blockColumns = "Id, Height, Hash, PrevHash, NextHash, MerkleRoot, Time, Ver, Nonce, Bits, Size, Weight, Extracted"
+	txColumns    = "Id, Hash, Ver, LockTime, Size, ReceivedTime, IsCoinbase, Extracted, Confirmed"
+	txinColumns  = "Id, TxId, InScript, Sequence, PrevOutHash, PrevOutIndex, PrevTxoutId, IsCoinbase, Calculated"
+	txoutColumns = "txout.Id, txout.TxId, txout.OutIndex, txout.Value, txout.ScriptId, txout.Type, txout.ReqSig, txout.IsCoinbase, txout.Extracted, txout.Spent, txout.RefTxinId"
+)
+
+// Queries holds the prepared statements of the hot lookup paths. Arguments
+// are always bound, never interpolated into the SQL text. Not found is
+// reported as sql.ErrNoRows.
+type Queries struct {
+	txoutByOutpoint   *sql.Stmt
+	addressIdsByTxout *sql.Stmt
+	addressesByTxout  *sql.Stmt
+	addressByAddress  *sql.Stmt
+	blockByHash       *sql.Stmt
+	blockByHeight     *sql.Stmt
+	txByHash          *sql.Stmt
+	txinsByTx         *sql.Stmt
+	txoutsByTx        *sql.Stmt
+	blockByTx         *sql.Stmt
+	txIndexInBlock    *sql.Stmt
+	txHashesByBlock   *sql.Stmt
+}
+
+func PrepareQueries(db *sql.DB) (*Queries, error) {
+	q := new(Queries)
+	stmts := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&q.txoutByOutpoint, "select " + txoutColumns + " from txout join tx on tx.Id=txout.TxId where tx.Hash=? and txout.OutIndex=?"},
+		{&q.addressIdsByTxout, "select AddressId from txoutaddress where TxoutId=?"},
+		{&q.addressesByTxout, "select address.Address from txoutaddress join address on address.Id=txoutaddress.AddressId where txoutaddress.TxoutId=? order by txoutaddress.Id"},
+		{&q.addressByAddress, "select Id, Address, Balance from address where Address=?"},
+		{&q.blockByHash, "select " + blockColumns + " from block where Hash=?"},
+		{&q.blockByHeight, "select " + blockColumns + " from block where Height=? limit 1"},
+		{&q.txByHash, "select " + txColumns + " from tx where Hash=?"},
+		{&q.txinsByTx, "select " + txinColumns + " from txin where TxId=? order by Id"},
+		{&q.txoutsByTx, "select " + txoutColumns + ", script.Script from txout left join script on script.Id=txout.ScriptId where txout.TxId=? order by txout.OutIndex"},
+		{&q.blockByTx, "select " + blockColumns + " from block where Id in (select BlockId from blocktx where TxId=?) order by Height desc, Id desc limit 1"},
+		//blocktx rows are inserted in block order.
+		{&q.txIndexInBlock, "select count(*) from blocktx where BlockId=? and Id<(select Id from blocktx where BlockId=? and TxId=?)"},
+		{&q.txHashesByBlock, "select tx.Hash from blocktx join tx on tx.Id=blocktx.TxId where blocktx.BlockId=? order by blocktx.Id"},
+	}
+	for _, s := range stmts {
+		stmt, err := db.Prepare(s.query)
+		if err != nil {
+			q.Close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return q, nil
+}
+
+func (q *Queries) Close() {
+	for _, stmt := range []*sql.Stmt{q.txoutByOutpoint, q.addressIdsByTxout, q.addressesByTxout, q.addressByAddress, q.blockByHash, q.blockByHeight, q.txByHash,
+		q.txinsByTx, q.txoutsByTx, q.blockByTx, q.txIndexInBlock, q.txHashesByBlock} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (q *Queries) TxoutByOutpoint(hash Hash, index int64) (*ModelTxout, error) {
+	out := new(ModelTxout)
+	err := q.txoutByOutpoint.QueryRow(hash, index).Scan(&out.Id, &out.TxId, &out.OutIndex, &out.Value, &out.ScriptId,
+		&out.Type, &out.ReqSig, &out.IsCoinbase, &out.Extracted, &out.Spent, &out.RefTxinId)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (q *Queries) AddressIdsByTxout(txoutId int64) ([]int64, error) {
+	rows, err := q.addressIdsByTxout.Query(txoutId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddressesByTxout returns the addresses an output pays to, once extracted.
+func (q *Queries) AddressesByTxout(txoutId int64) ([]string, error) {
+	rows, err := q.addressesByTxout.Query(txoutId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var addresses []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+func (q *Queries) AddressByAddress(address string) (*ModelAddress, error) {
+	a := new(ModelAddress)
+	err := q.addressByAddress.QueryRow(address).Scan(&a.Id, &a.Address, &a.Balance)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func scanBlock(row *sql.Row) (*ModelBlock, error) {
+	b := new(ModelBlock)
+	err := row.Scan(&b.Id, &b.Height, &b.Hash, &b.PrevHash, &b.NextHash, &b.MerkleRoot, &b.Time, &b.Ver, &b.Nonce, &b.Bits, &b.Size, &b.Weight, &b.Extracted)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (q *Queries) BlockByHash(hash Hash) (*ModelBlock, error) {
+	return scanBlock(q.blockByHash.QueryRow(hash))
+}
+
+func (q *Queries) BlockByHeight(height int64) (*ModelBlock, error) {
+	return scanBlock(q.blockByHeight.QueryRow(height))
+}
+
+func (q *Queries) TxByHash(hash Hash) (*ModelTx, error) {
+	tx := new(ModelTx)
+	err := q.txByHash.QueryRow(hash).Scan(&tx.Id, &tx.Hash, &tx.Ver, &tx.LockTime, &tx.Size, &tx.ReceivedTime, &tx.IsCoinbase, &tx.Extracted, &tx.Confirmed)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (q *Queries) TxinsByTx(txId int64) ([]*ModelTxin, error) {
+	rows, err := q.txinsByTx.Query(txId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ins []*ModelTxin
+	for rows.Next() {
+		in := new(ModelTxin)
+		if err := rows.Scan(&in.Id, &in.TxId, &in.InScript, &in.Sequence, &in.PrevOutHash, &in.PrevOutIndex,
+			&in.PrevTxoutId, &in.IsCoinbase, &in.Calculated); err != nil {
+			return nil, err
+		}
+		ins = append(ins, in)
+	}
+	return ins, rows.Err()
+}
+
+// TxoutsByTx returns the outputs of a tx in order, with their scripts.
+func (q *Queries) TxoutsByTx(txId int64) ([]*ModelTxout, error) {
+	rows, err := q.txoutsByTx.Query(txId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var outs []*ModelTxout
+	for rows.Next() {
+		out := new(ModelTxout)
+		if err := rows.Scan(&out.Id, &out.TxId, &out.OutIndex, &out.Value, &out.ScriptId, &out.Type, &out.ReqSig,
+			&out.IsCoinbase, &out.Extracted, &out.Spent, &out.RefTxinId, &out.OutScript); err != nil {
+			return nil, err
+		}
+		outs = append(outs, out)
+	}
+	return outs, rows.Err()
+}
+
+// BlockByTx returns the block a tx is confirmed in. A tx in several blocks,
+// a BIP30 duplicate or one also mined in a fork block, gets the highest.
+func (q *Queries) BlockByTx(txId int64) (*ModelBlock, error) {
+	return scanBlock(q.blockByTx.QueryRow(txId))
+}
+
+// TxIndexInBlock returns the position of a tx in a block.
+func (q *Queries) TxIndexInBlock(blockId int64, txId int64) (int64, error) {
+	var index int64
+	err := q.txIndexInBlock.QueryRow(blockId, blockId, txId).Scan(&index)
+	return index, err
+}
+
+// TxHashesByBlock returns the txids of a block in block order.
+func (q *Queries) TxHashesByBlock(blockId int64) ([]Hash, error) {
+	rows, err := q.txHashesByBlock.Query(blockId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hashes []Hash
+	for rows.Next() {
+		var hash Hash
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
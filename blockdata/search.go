@@ -0,0 +1,55 @@
+package blockdata
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/coopernurse/gorp"
+	"strings"
+)
+
+var ErrHashPrefix = errors.New("Hash prefix must be 1 to 64 hex digits.")
+
+// HashPrefixRange returns the lowest and highest hash starting with the hex
+// prefix. Hashes are stored in display order, so the range is a scan of
+// the unique hash index.
+func HashPrefixRange(prefix string) (Hash, Hash, error) {
+	var low, high Hash
+	if len(prefix) == 0 || len(prefix) > 2*BytesPerBlockHash {
+		return low, high, ErrHashPrefix
+	}
+	lowBytes, err := hex.DecodeString(prefix + strings.Repeat("0", 2*BytesPerBlockHash-len(prefix)))
+	if err != nil {
+		return low, high, err
+	}
+	highBytes, err := hex.DecodeString(prefix + strings.Repeat("f", 2*BytesPerBlockHash-len(prefix)))
+	if err != nil {
+		return low, high, err
+	}
+	copy(low[:], lowBytes)
+	copy(high[:], highBytes)
+	return low, high, nil
+}
+
+// BlocksByHashPrefix returns up to limit blocks whose hash starts with the
+// hex prefix.
+func BlocksByHashPrefix(exec gorp.SqlExecutor, prefix string, limit int) ([]*ModelBlock, error) {
+	low, high, err := HashPrefixRange(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []*ModelBlock
+	_, err = exec.Select(&blocks, "select * from block where Hash between ? and ? order by Hash limit ?", low, high, limit)
+	return blocks, err
+}
+
+// TxsByHashPrefix returns up to limit txs whose hash starts with the hex
+// prefix.
+func TxsByHashPrefix(exec gorp.SqlExecutor, prefix string, limit int) ([]*ModelTx, error) {
+	low, high, err := HashPrefixRange(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var txs []*ModelTx
+	_, err = exec.Select(&txs, "select * from tx where Hash between ? and ? order by Hash limit ?", low, high, limit)
+	return txs, err
+}
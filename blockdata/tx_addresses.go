@@ -0,0 +1,57 @@
+package blockdata
+
+import (
+	"fmt"
+	"github.com/coopernurse/gorp"
+)
+
+// TxAddress is an address a tx pays to or spends from.
+type TxAddress struct {
+	Hash    Hash
+	Address string
+}
+
+// Outputs and spent outputs joined to their addresses, for the txs matched
+// by the condition on tx.
+const txAddressSelect = `select tx.Hash as Hash, address.Address as Address from tx
+	join txout on txout.TxId=tx.Id
+	join txoutaddress on txoutaddress.TxoutId=txout.Id
+	join address on address.Id=txoutaddress.AddressId
+	where %[1]s
+	union
+	select tx.Hash as Hash, address.Address as Address from tx
+	join txin on txin.TxId=tx.Id
+	join txoutaddress on txoutaddress.TxoutId=txin.PrevTxoutId
+	join address on address.Id=txoutaddress.AddressId
+	where %[1]s`
+
+func selectTxAddresses(exec gorp.SqlExecutor, cond string, arg interface{}) (map[Hash][]string, error) {
+	var rows []*TxAddress
+	_, err := exec.Select(&rows, fmt.Sprintf(txAddressSelect, cond), arg, arg)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[Hash][]string)
+	for _, row := range rows {
+		addresses[row.Hash] = append(addresses[row.Hash], row.Address)
+	}
+	return addresses, nil
+}
+
+// AddressesOfTx returns the addresses of the extracted outputs and
+// resolved inputs of a tx.
+func AddressesOfTx(exec gorp.SqlExecutor, txId int64) ([]string, error) {
+	addresses, err := selectTxAddresses(exec, "tx.Id=?", txId)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addresses {
+		return a, nil
+	}
+	return nil, nil
+}
+
+// AddressesOfBlockTxs is AddressesOfTx for all txs of a block, by tx hash.
+func AddressesOfBlockTxs(exec gorp.SqlExecutor, blockId int64) (map[Hash][]string, error) {
+	return selectTxAddresses(exec, "tx.Id in (select TxId from blocktx where BlockId=?)", blockId)
+}
@@ -0,0 +1,200 @@
+package blockdata
+
+import (
+	"container/list"
+	"database/sql"
+	"github.com/conformal/btcscript"
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	//Rough memory cost of one cached outpoint, used to turn the configured size into a capacity.
+	utxoEntryBytes = 256
+	//Pending spends written per flush.
+	utxoMaxPending = 10000
+
+	DefaultUtxoCacheMB = 256
+)
+
+type Outpoint struct {
+	Hash  Hash
+	Index int64
+}
+
+type UtxoEntry struct {
+	TxoutId    int64
+	Value      int64
+	Type       btcscript.ScriptClass
+	AddressIds []int64
+}
+
+type utxoElement struct {
+	outpoint Outpoint
+	entry    *UtxoEntry
+}
+
+type utxoSpend struct {
+	TxoutId int64
+	TxinId  int64
+}
+
+// UtxoCache keeps unspent outputs in memory so that spends resolve without a
+// DB round-trip. Spends are buffered and written back by Flush in one DB
+// transaction together with the Calculated flag of their txins, so after a
+// crash the unflushed txins are simply resolved again.
+type UtxoCache struct {
+	queries    *Queries
+	maxEntries int
+	entries    map[Outpoint]*list.Element
+	lru        *list.List
+
+	spends     []utxoSpend
+	balances   map[int64]int64
+	calculated []int64
+
+	Hits   int64
+	Misses int64
+}
+
+func NewUtxoCache(sizeMB int64, queries *Queries) *UtxoCache {
+	if sizeMB <= 0 {
+		sizeMB = DefaultUtxoCacheMB
+	}
+	return &UtxoCache{
+		queries:    queries,
+		maxEntries: int(sizeMB * 1024 * 1024 / utxoEntryBytes),
+		entries:    make(map[Outpoint]*list.Element),
+		lru:        list.New(),
+		balances:   make(map[int64]int64),
+	}
+}
+
+func (c *UtxoCache) Len() int {
+	return c.lru.Len()
+}
+
+// Add caches an output which is already stored in DB.
+func (c *UtxoCache) Add(op Outpoint, entry *UtxoEntry) {
+	if elem, ok := c.entries[op]; ok {
+		elem.Value.(*utxoElement).entry = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[op] = c.lru.PushFront(&utxoElement{op, entry})
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*utxoElement).outpoint)
+	}
+}
+
+// Forget drops an output which no longer exists, like one of a tx evicted
+// from the mempool.
+func (c *UtxoCache) Forget(op Outpoint) {
+	c.take(op)
+}
+
+func (c *UtxoCache) take(op Outpoint) *UtxoEntry {
+	elem, ok := c.entries[op]
+	if !ok {
+		return nil
+	}
+	c.lru.Remove(elem)
+	delete(c.entries, op)
+	return elem.Value.(*utxoElement).entry
+}
+
+func (c *UtxoCache) lookup(op Outpoint) (*UtxoEntry, error) {
+	txout, err := c.queries.TxoutByOutpoint(op.Hash, op.Index)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &UtxoEntry{
+		TxoutId: txout.Id,
+		Value:   txout.Value,
+		Type:    txout.Type,
+	}
+	if entry.AddressIds, err = c.queries.AddressIdsByTxout(txout.Id); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Spend resolves the output spent by txin and buffers the resulting writes.
+func (c *UtxoCache) Spend(txin *ModelTxin) error {
+	c.calculated = append(c.calculated, txin.Id)
+	if txin.IsCoinbase {
+		return nil
+	}
+	op := Outpoint{txin.PrevOutHash, txin.PrevOutIndex}
+	entry := c.take(op)
+	if entry != nil {
+		c.Hits++
+	} else {
+		c.Misses++
+		var err error
+		entry, err = c.lookup(op)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			log.Info("No matched txout found. Hash:%s, Index:%d.", op.Hash, op.Index)
+			return nil
+		}
+	}
+	c.spends = append(c.spends, utxoSpend{entry.TxoutId, txin.Id})
+	if CountsToBalance(entry.Type) {
+		for _, addressId := range entry.AddressIds {
+			c.balances[addressId] -= entry.Value
+		}
+	}
+	return nil
+}
+
+func (c *UtxoCache) NeedFlush() bool {
+	return len(c.calculated) >= utxoMaxPending
+}
+
+// Flush writes every buffered spend in a single DB transaction.
+func (c *UtxoCache) Flush(dbmap *gorp.DbMap) error {
+	if len(c.calculated) == 0 {
+		return nil
+	}
+	trans, err := dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	for _, s := range c.spends {
+		if _, err := trans.Exec("update txout set Spent=1, RefTxinId=? where Id=?", s.TxinId, s.TxoutId); err != nil {
+			trans.Rollback()
+			return err
+		}
+		if _, err := trans.Exec("update txin set PrevTxoutId=? where Id=?", s.TxoutId, s.TxinId); err != nil {
+			trans.Rollback()
+			return err
+		}
+	}
+	for addressId, delta := range c.balances {
+		if _, err := trans.Exec("update address set Balance=Balance+? where Id=?", delta, addressId); err != nil {
+			trans.Rollback()
+			return err
+		}
+	}
+	for _, txinId := range c.calculated {
+		if _, err := trans.Exec("update txin set Calculated=1 where Id=?", txinId); err != nil {
+			trans.Rollback()
+			return err
+		}
+	}
+	if err := trans.Commit(); err != nil {
+		return err
+	}
+	log.Info("UTXO cache flushed %d txins, %d spends. Cached:%d, hits:%d, misses:%d.", len(c.calculated), len(c.spends), c.Len(), c.Hits, c.Misses)
+	c.spends = nil
+	c.calculated = nil
+	c.balances = make(map[int64]int64)
+	return nil
+}
@@ -0,0 +1,41 @@
+package blockdata
+
+import (
+	"github.com/conformal/btcscript"
+	"testing"
+)
+
+func TestUtxoCacheEvict01(t *testing.T) {
+	cache := NewUtxoCache(1, nil)
+	cache.maxEntries = 2
+	cache.Add(Outpoint{Hash{1}, 0}, &UtxoEntry{TxoutId: 1})
+	cache.Add(Outpoint{Hash{2}, 0}, &UtxoEntry{TxoutId: 2})
+	cache.Add(Outpoint{Hash{3}, 0}, &UtxoEntry{TxoutId: 3})
+	if cache.Len() != 2 {
+		t.Errorf("Cache holds %d entries, expected 2.", cache.Len())
+	}
+	if cache.take(Outpoint{Hash{1}, 0}) != nil {
+		t.Error("Oldest entry was not evicted.")
+	}
+	if entry := cache.take(Outpoint{Hash{3}, 0}); entry == nil || entry.TxoutId != 3 {
+		t.Error("Newest entry not found.")
+	}
+}
+
+func TestUtxoCacheSpend01(t *testing.T) {
+	cache := NewUtxoCache(1, nil)
+	cache.Add(Outpoint{Hash{1}, 1}, &UtxoEntry{TxoutId: 7, Value: 50, Type: btcscript.PubKeyHashTy, AddressIds: []int64{3}})
+	txin := &ModelTxin{Id: 9, PrevOutHash: Hash{1}, PrevOutIndex: 1}
+	if err := cache.Spend(txin); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Hits != 1 || cache.Len() != 0 {
+		t.Errorf("Spend not served from cache. Hits:%d, Len:%d.", cache.Hits, cache.Len())
+	}
+	if len(cache.spends) != 1 || cache.spends[0].TxoutId != 7 || cache.spends[0].TxinId != 9 {
+		t.Error("Spend not buffered.")
+	}
+	if cache.balances[3] != -50 {
+		t.Errorf("Balance delta is %d, expected -50.", cache.balances[3])
+	}
+}
@@ -0,0 +1,492 @@
+package blockdata
+
+import (
+	"encoding/json"
+	"github.com/coopernurse/gorp"
+	"strings"
+	"time"
+)
+
+// Delivery states. Pending deliveries are retried until they succeed or
+// run out of attempts, dead ones are kept for the delivery log.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead"
+)
+
+// Kinds of watched values.
+const (
+	WebhookWatchAddress = "address"
+	WebhookWatchTxid    = "txid"
+)
+
+// Watched values looked up per query.
+const maxWatchLookup = 1000
+
+// Blocks and mempool txs queued per transaction by QueueWebhooks.
+const webhookQueueBatch = 100
+
+// ModelWebhook is a URL notified of txs paying to or spending from its
+// watched addresses, or having one of its watched txids, once they reach
+// Confirmations. PublicId identifies it in the API, Secret signs payloads.
+type ModelWebhook struct {
+	Id            int64
+	PublicId      string
+	Url           string
+	Secret        string
+	Confirmations int64
+	Active        bool
+	Created       time.Time
+}
+
+// ModelWebhookWatch is an address or a txid in hex watched by a webhook,
+// Kind tells which.
+type ModelWebhookWatch struct {
+	Id        int64
+	WebhookId int64
+	Kind      string
+	Value     string
+}
+
+// ModelWebhookDelivery is the outbox entry of one tx for one webhook.
+type ModelWebhookDelivery struct {
+	Id          int64
+	WebhookId   int64
+	TxHash      Hash
+	Payload     string
+	Status      string
+	Attempts    int64
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+}
+
+// ModelWebhookAttempt is one POST of a delivery, StatusCode is 0 when no
+// response was received. Duration is in milliseconds.
+type ModelWebhookAttempt struct {
+	Id         int64
+	DeliveryId int64
+	Time       time.Time
+	StatusCode int64
+	Error      string
+	Duration   int64
+}
+
+// ModelWebhookMark is how far QueueWebhooks got, the only row has Id 1.
+// Blocks are queued up to BlockHeight and mempool txs up to TxId.
+type ModelWebhookMark struct {
+	Id          int64
+	BlockHeight int64
+	TxId        int64
+}
+
+// WebhookPayload is the JSON body POSTed for a delivery. Addresses are the
+// watched addresses the tx pays to or spends from.
+type WebhookPayload struct {
+	Event         string   `json:"event"`
+	WebhookId     string   `json:"webhook_id"`
+	Txid          string   `json:"txid"`
+	Confirmations int64    `json:"confirmations"`
+	BlockHash     string   `json:"block_hash,omitempty"`
+	BlockHeight   *int64   `json:"block_height,omitempty"`
+	Addresses     []string `json:"addresses"`
+	Time          int64    `json:"time"`
+}
+
+// DueWebhookDelivery is a pending delivery with where to send it.
+type DueWebhookDelivery struct {
+	Id       int64
+	Url      string
+	Secret   string
+	Payload  string
+	Attempts int64
+}
+
+type webhookThreshold struct {
+	Confirmations int64
+}
+
+type webhookMatch struct {
+	WebhookId int64
+	PublicId  string
+	Value     string
+}
+
+// webhookTx is a tx looked up for webhooks, block is nil while it is
+// unconfirmed.
+type webhookTx struct {
+	hash          Hash
+	addresses     []string
+	confirmations int64
+	block         *ModelBlock
+}
+
+func InitModelWebhookTables(dbmap *gorp.DbMap) {
+	dbmap.AddTableWithName(ModelWebhook{}, "webhook").SetKeys(true, "Id")
+	dbmap.AddTableWithName(ModelWebhookWatch{}, "webhookwatch").SetKeys(true, "Id")
+	dbmap.AddTableWithName(ModelWebhookDelivery{}, "webhookdelivery").SetKeys(true, "Id")
+	dbmap.AddTableWithName(ModelWebhookAttempt{}, "webhookattempt").SetKeys(true, "Id")
+	dbmap.AddTableWithName(ModelWebhookMark{}, "webhookmark").SetKeys(false, "Id")
+}
+
+// CreateWebhook stores hook with the addresses and txids it watches.
+func CreateWebhook(trans *gorp.Transaction, hook *ModelWebhook, watches []*ModelWebhookWatch) error {
+	if err := trans.Insert(hook); err != nil {
+		return err
+	}
+	for _, watch := range watches {
+		watch.WebhookId = hook.Id
+		if err := trans.Insert(watch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookByPublicId returns a webhook, deleted or not, sql.ErrNoRows if
+// there is none.
+func WebhookByPublicId(exec gorp.SqlExecutor, publicId string) (*ModelWebhook, error) {
+	hook := new(ModelWebhook)
+	err := exec.SelectOne(hook, "select * from webhook where PublicId=?", publicId)
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func WebhookWatches(exec gorp.SqlExecutor, webhookId int64) ([]*ModelWebhookWatch, error) {
+	var watches []*ModelWebhookWatch
+	_, err := exec.Select(&watches, "select * from webhookwatch where WebhookId=? order by Id", webhookId)
+	return watches, err
+}
+
+// DeactivateWebhook stops deliveries to a webhook. Its pending deliveries
+// are given up, the delivery log stays.
+func DeactivateWebhook(trans *gorp.Transaction, webhookId int64) error {
+	if _, err := trans.Exec("update webhook set Active=0 where Id=?", webhookId); err != nil {
+		return err
+	}
+	_, err := trans.Exec("update webhookdelivery set Status=?, LastError=? where WebhookId=? and Status=?",
+		WebhookDead, "Webhook deleted.", webhookId, WebhookPending)
+	return err
+}
+
+// WebhookDeliveries returns the deliveries of a webhook, newest first.
+// before is a delivery id, -1 to start with the newest one.
+func WebhookDeliveries(exec gorp.SqlExecutor, webhookId int64, before int64, limit int64) ([]*ModelWebhookDelivery, error) {
+	var deliveries []*ModelWebhookDelivery
+	var err error
+	if before < 0 {
+		_, err = exec.Select(&deliveries, "select * from webhookdelivery where WebhookId=? order by Id desc limit ?", webhookId, limit)
+	} else {
+		_, err = exec.Select(&deliveries, "select * from webhookdelivery where WebhookId=? and Id<? order by Id desc limit ?", webhookId, before, limit)
+	}
+	return deliveries, err
+}
+
+func WebhookAttempts(exec gorp.SqlExecutor, deliveryId int64) ([]*ModelWebhookAttempt, error) {
+	var attempts []*ModelWebhookAttempt
+	_, err := exec.Select(&attempts, "select * from webhookattempt where DeliveryId=? order by Id", deliveryId)
+	return attempts, err
+}
+
+// DueWebhookDeliveries returns pending deliveries of active webhooks whose
+// next attempt is due, oldest first.
+func DueWebhookDeliveries(exec gorp.SqlExecutor, now time.Time, limit int64) ([]*DueWebhookDelivery, error) {
+	var due []*DueWebhookDelivery
+	_, err := exec.Select(&due, `select webhookdelivery.Id as Id, webhook.Url as Url, webhook.Secret as Secret,
+		webhookdelivery.Payload as Payload, webhookdelivery.Attempts as Attempts
+		from webhookdelivery join webhook on webhook.Id=webhookdelivery.WebhookId
+		where webhookdelivery.Status=? and webhookdelivery.NextAttempt<=? and webhook.Active=1
+		order by webhookdelivery.NextAttempt, webhookdelivery.Id limit ?`, WebhookPending, now, limit)
+	return due, err
+}
+
+// RecordWebhookAttempt logs an attempt and moves its delivery to status,
+// to be tried again at next while it stays pending. Deliveries given up
+// meanwhile stay dead.
+func RecordWebhookAttempt(trans *gorp.Transaction, attempt *ModelWebhookAttempt, status string, next time.Time) error {
+	if err := trans.Insert(attempt); err != nil {
+		return err
+	}
+	_, err := trans.Exec("update webhookdelivery set Status=?, Attempts=Attempts+1, NextAttempt=?, LastError=? where Id=? and Status=?",
+		status, next, attempt.Error, attempt.DeliveryId, WebhookPending)
+	return err
+}
+
+// QueueWebhooks fills the outbox with what was indexed since its last run,
+// the blocks up to the tip and the mempool txs inserted since. The mark is
+// moved in the same transaction as the deliveries are inserted, so blocks
+// and txs whose queueing failed or was cut short by a stop are queued by
+// the next run.
+func QueueWebhooks(dbmap *gorp.DbMap) error {
+	for {
+		trans, err := dbmap.Begin()
+		if err != nil {
+			return err
+		}
+		done, err := queueWebhookBatch(trans)
+		if err != nil {
+			trans.Rollback()
+			return err
+		}
+		if err := trans.Commit(); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// queueWebhookBatch queues up to webhookQueueBatch blocks and mempool txs
+// after the mark and moves it. Returns true once the mark is at the index.
+func queueWebhookBatch(trans *gorp.Transaction) (bool, error) {
+	mark := new(ModelWebhookMark)
+	if err := trans.SelectOne(mark, "select * from webhookmark where Id=1 for update"); err != nil {
+		return false, err
+	}
+	tipHeight, err := trans.SelectInt("select coalesce(max(Height), -1) from block")
+	if err != nil {
+		return false, err
+	}
+	maxTxId, err := trans.SelectInt("select coalesce(max(Id), 0) from tx")
+	if err != nil {
+		return false, err
+	}
+	active, err := trans.SelectInt("select count(*) from webhook where Active=1")
+	if err != nil {
+		return false, err
+	}
+	//Webhooks registered later only get txs reaching them afterwards.
+	if active == 0 {
+		mark.BlockHeight, mark.TxId = tipHeight, maxTxId
+		_, err := trans.Update(mark)
+		return true, err
+	}
+
+	//Txs confirmed meanwhile are queued with their block.
+	var txs []*ModelTx
+	if _, err := trans.Select(&txs, "select * from tx where Id>? and Id<=? and Confirmed=0 order by Id limit ?", mark.TxId, maxTxId, webhookQueueBatch); err != nil {
+		return false, err
+	}
+	for _, tx := range txs {
+		if err := EnqueueTxWebhooks(trans, tx); err != nil {
+			return false, err
+		}
+	}
+	if len(txs) == webhookQueueBatch {
+		mark.TxId = txs[len(txs)-1].Id
+	} else {
+		mark.TxId = maxTxId
+	}
+
+	to := queueWebhooksUpTo(mark.BlockHeight, tipHeight)
+	for height := mark.BlockHeight + 1; height <= to; height++ {
+		block := new(ModelBlock)
+		if err := trans.SelectOne(block, "select * from block where Height=? limit 1", height); err != nil {
+			return false, err
+		}
+		if err := EnqueueBlockWebhooks(trans, block); err != nil {
+			return false, err
+		}
+	}
+	if to > mark.BlockHeight {
+		mark.BlockHeight = to
+	}
+	if _, err := trans.Update(mark); err != nil {
+		return false, err
+	}
+	return mark.BlockHeight >= tipHeight && mark.TxId == maxTxId, nil
+}
+
+// queueWebhooksUpTo is the last height of the next batch after the mark.
+func queueWebhooksUpTo(markHeight int64, tipHeight int64) int64 {
+	if tipHeight-markHeight > webhookQueueBatch {
+		return markHeight + webhookQueueBatch
+	}
+	return tipHeight
+}
+
+// RewindWebhookMark has the blocks above height queued again, for the
+// blocks connected there after a reorg. Call it in the transaction
+// disconnecting them.
+func RewindWebhookMark(trans *gorp.Transaction, height int64) error {
+	_, err := trans.Exec("update webhookmark set BlockHeight=? where BlockHeight>?", height, height)
+	return err
+}
+
+// EnqueueTxWebhooks queues a mempool tx for the webhooks notified at 0
+// confirmations.
+func EnqueueTxWebhooks(exec gorp.SqlExecutor, tx *ModelTx) error {
+	addresses, err := AddressesOfTx(exec, tx.Id)
+	if err != nil {
+		return err
+	}
+	return enqueueWebhooks(exec, []*webhookTx{{hash: tx.Hash, addresses: addresses}}, []int64{0})
+}
+
+// EnqueueBlockWebhooks queues the txs which reach the confirmations of a
+// webhook with tip. Webhooks notified at 0 confirmations also get the txs
+// of tip they did not see in the mempool.
+func EnqueueBlockWebhooks(exec gorp.SqlExecutor, tip *ModelBlock) error {
+	var rows []*webhookThreshold
+	if _, err := exec.Select(&rows, "select distinct Confirmations from webhook where Active=1"); err != nil {
+		return err
+	}
+	thresholds := make(map[int64][]int64)
+	lowest := tip.Height
+	for _, row := range rows {
+		depth := row.Confirmations
+		if depth < 1 {
+			depth = 1
+		}
+		if height := tip.Height - depth + 1; height >= 0 {
+			thresholds[height] = append(thresholds[height], row.Confirmations)
+			if height < lowest {
+				lowest = height
+			}
+		}
+	}
+	//Fork blocks share heights with the main chain, so the threshold blocks
+	//are found by walking back from the tip.
+	block := tip
+	for {
+		if confirmations, ok := thresholds[block.Height]; ok {
+			if err := enqueueBlockWebhooks(exec, tip, block, confirmations); err != nil {
+				return err
+			}
+		}
+		if block.Height <= lowest {
+			return nil
+		}
+		prev := new(ModelBlock)
+		if err := exec.SelectOne(prev, "select * from block where Hash=?", block.PrevHash); err != nil {
+			return err
+		}
+		block = prev
+	}
+}
+
+// enqueueBlockWebhooks queues the deliveries of the txs of block, which got
+// the confirmations of the thresholds given.
+func enqueueBlockWebhooks(exec gorp.SqlExecutor, tip *ModelBlock, block *ModelBlock, confirmations []int64) error {
+	var txs []*ModelTx
+	if _, err := exec.Select(&txs, "select tx.* from tx join blocktx on blocktx.TxId=tx.Id where blocktx.BlockId=? order by blocktx.Id", block.Id); err != nil {
+		return err
+	}
+	addresses, err := AddressesOfBlockTxs(exec, block.Id)
+	if err != nil {
+		return err
+	}
+	wtxs := make([]*webhookTx, len(txs))
+	for i, tx := range txs {
+		wtxs[i] = &webhookTx{hash: tx.Hash, addresses: addresses[tx.Hash], confirmations: tip.Height - block.Height + 1, block: block}
+	}
+	return enqueueWebhooks(exec, wtxs, confirmations)
+}
+
+// enqueueWebhooks queues a delivery for every active webhook with one of the
+// confirmations watching a tx or one of its addresses. Txs queued for a
+// webhook before are skipped.
+func enqueueWebhooks(exec gorp.SqlExecutor, txs []*webhookTx, confirmations []int64) error {
+	byValue := make(map[string][]*webhookTx)
+	var values []string
+	for _, tx := range txs {
+		for _, value := range append([]string{tx.hash.String()}, tx.addresses...) {
+			if _, ok := byValue[value]; !ok {
+				values = append(values, value)
+			}
+			byValue[value] = append(byValue[value], tx)
+		}
+	}
+	matches, err := matchWebhooks(exec, values, confirmations)
+	if err != nil {
+		return err
+	}
+
+	type key struct {
+		webhookId int64
+		hash      Hash
+	}
+	var keys []key
+	payloads := make(map[key]*WebhookPayload)
+	now := time.Now()
+	for _, match := range matches {
+		for _, tx := range byValue[match.Value] {
+			k := key{match.WebhookId, tx.hash}
+			payload, ok := payloads[k]
+			if !ok {
+				payload = newWebhookPayload(match.PublicId, tx, now)
+				payloads[k] = payload
+				keys = append(keys, k)
+			}
+			if match.Value != tx.hash.String() {
+				payload.Addresses = append(payload.Addresses, match.Value)
+			}
+		}
+	}
+	for _, k := range keys {
+		body, err := json.Marshal(payloads[k])
+		if err != nil {
+			return err
+		}
+		_, err = exec.Exec(`insert ignore into webhookdelivery (WebhookId, TxHash, Payload, Status, Attempts, NextAttempt, LastError, Created)
+			values (?, ?, ?, ?, 0, ?, '', ?)`, k.webhookId, k.hash, string(body), WebhookPending, now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newWebhookPayload(publicId string, tx *webhookTx, now time.Time) *WebhookPayload {
+	payload := &WebhookPayload{
+		Event:         "tx",
+		WebhookId:     publicId,
+		Txid:          tx.hash.String(),
+		Confirmations: tx.confirmations,
+		Addresses:     []string{},
+		Time:          now.Unix(),
+	}
+	if tx.block != nil {
+		height := tx.block.Height
+		payload.BlockHash = tx.block.Hash.String()
+		payload.BlockHeight = &height
+	}
+	return payload
+}
+
+// matchWebhooks returns the watches of active webhooks with one of the
+// confirmations on any of values.
+func matchWebhooks(exec gorp.SqlExecutor, values []string, confirmations []int64) ([]*webhookMatch, error) {
+	var matches []*webhookMatch
+	for start := 0; start < len(values); start += maxWatchLookup {
+		end := start + maxWatchLookup
+		if end > len(values) {
+			end = len(values)
+		}
+		args := make([]interface{}, 0, len(confirmations)+end-start)
+		for _, c := range confirmations {
+			args = append(args, c)
+		}
+		for _, value := range values[start:end] {
+			args = append(args, value)
+		}
+		var rows []*webhookMatch
+		_, err := exec.Select(&rows, `select webhook.Id as WebhookId, webhook.PublicId as PublicId, webhookwatch.Value as Value
+			from webhookwatch join webhook on webhook.Id=webhookwatch.WebhookId
+			where webhook.Active=1 and webhook.Confirmations in (`+placeholders(len(confirmations))+`)
+			and webhookwatch.Value in (`+placeholders(end-start)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, rows...)
+	}
+	return matches, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,42 @@
+package blockdata
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookPayload01(t *testing.T) {
+	hash, _ := NewHashFromStr("f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16")
+	now := time.Unix(1400000000, 0)
+	payload := newWebhookPayload("ab", &webhookTx{hash: hash}, now)
+	body, _ := json.Marshal(payload)
+	if strings.Contains(string(body), "block_") || !strings.Contains(string(body), `"addresses":[]`) {
+		t.Errorf("Unexpected unconfirmed payload %s.", body)
+	}
+	payload = newWebhookPayload("ab", &webhookTx{hash: hash, confirmations: 1, block: &ModelBlock{Height: 0}}, now)
+	body, _ = json.Marshal(payload)
+	if !strings.Contains(string(body), `"block_height":0`) || !strings.Contains(string(body), `"confirmations":1`) {
+		t.Errorf("Unexpected confirmed payload %s.", body)
+	}
+}
+
+func TestPlaceholders01(t *testing.T) {
+	if placeholders(1) != "?" || placeholders(3) != "?,?,?" {
+		t.Errorf("Unexpected placeholders %q %q.", placeholders(1), placeholders(3))
+	}
+}
+
+func TestQueueWebhooksUpTo01(t *testing.T) {
+	for _, c := range []struct{ mark, tip, to int64 }{
+		{-1, -1, -1},
+		{10, 12, 12},
+		{-1, 5000, webhookQueueBatch - 1},
+		{5000, 4990, 4990},
+	} {
+		if to := queueWebhooksUpTo(c.mark, c.tip); to != c.to {
+			t.Errorf("Mark %d, tip %d: got %d, expected %d.", c.mark, c.tip, to, c.to)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package blockdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/conformal/btcwire"
+	"io"
+)
+
+var ErrBadSerialization = errors.New("Malformed tx or block serialization.")
+
+// WireSize is the size of a tx or block in the BIP144 serialization. Base
+// leaves the witness data out and is what btcwire serializes.
+type WireSize struct {
+	Base  int64
+	Total int64
+}
+
+// Weight is defined by BIP141, 4 times the size without witness data.
+func (s WireSize) Weight() int64 {
+	return s.Base*3 + s.Total
+}
+
+// Vsize is the weight in virtual bytes, rounded up.
+func (s WireSize) Vsize() int64 {
+	return (s.Weight() + 3) / 4
+}
+
+// DecodeMsgTx reads a tx serialized with or without witness data. btcwire
+// has no witness fields, so it is dropped and TxSha gives the txid.
+func DecodeMsgTx(raw []byte) (*btcwire.MsgTx, WireSize, error) {
+	r := bytes.NewReader(raw)
+	msg, size, err := readMsgTx(r)
+	if err == nil && r.Len() != 0 {
+		err = ErrBadSerialization
+	}
+	return msg, size, err
+}
+
+// DecodeMsgBlock reads a block whose txs may carry witness data. sizes holds
+// the size of every tx.
+func DecodeMsgBlock(raw []byte) (msg *btcwire.MsgBlock, size WireSize, sizes []WireSize, err error) {
+	r := bytes.NewReader(raw)
+	msg = new(btcwire.MsgBlock)
+	if err = msg.Header.Deserialize(r); err != nil {
+		return nil, size, nil, err
+	}
+	count, err := readCount(r)
+	if err != nil {
+		return nil, size, nil, err
+	}
+	size.Base = int64(len(raw) - r.Len())
+	for i := uint64(0); i < count; i++ {
+		tx, txSize, err := readMsgTx(r)
+		if err != nil {
+			return nil, size, nil, err
+		}
+		msg.Transactions = append(msg.Transactions, tx)
+		sizes = append(sizes, txSize)
+		size.Base += txSize.Base
+	}
+	if r.Len() != 0 {
+		return nil, size, nil, ErrBadSerialization
+	}
+	size.Total = int64(len(raw))
+	return msg, size, sizes, nil
+}
+
+func readMsgTx(r *bytes.Reader) (*btcwire.MsgTx, WireSize, error) {
+	var size WireSize
+	start := r.Len()
+	msg := new(btcwire.MsgTx)
+	if err := binary.Read(r, binary.LittleEndian, &msg.Version); err != nil {
+		return nil, size, err
+	}
+	count, err := readCount(r)
+	if err != nil {
+		return nil, size, err
+	}
+	//No input count but the marker, if followed by the flag. Otherwise the
+	//tx has no inputs, bitcoind decides the same way.
+	witness := false
+	if count == 0 {
+		flag, err := r.ReadByte()
+		if err != nil {
+			return nil, size, err
+		}
+		if flag == 1 {
+			witness = true
+			if count, err = readCount(r); err != nil {
+				return nil, size, err
+			}
+		} else {
+			r.UnreadByte()
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		in := new(btcwire.TxIn)
+		if _, err := io.ReadFull(r, in.PreviousOutPoint.Hash[:]); err != nil {
+			return nil, size, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &in.PreviousOutPoint.Index); err != nil {
+			return nil, size, err
+		}
+		if in.SignatureScript, err = readBytes(r); err != nil {
+			return nil, size, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &in.Sequence); err != nil {
+			return nil, size, err
+		}
+		msg.TxIn = append(msg.TxIn, in)
+	}
+	if count, err = readCount(r); err != nil {
+		return nil, size, err
+	}
+	for i := uint64(0); i < count; i++ {
+		out := new(btcwire.TxOut)
+		if err := binary.Read(r, binary.LittleEndian, &out.Value); err != nil {
+			return nil, size, err
+		}
+		if out.PkScript, err = readBytes(r); err != nil {
+			return nil, size, err
+		}
+		msg.TxOut = append(msg.TxOut, out)
+	}
+	witnessLen := 0
+	if witness {
+		//Marker and flag
+		witnessLen = 2
+		before := r.Len()
+		for range msg.TxIn {
+			items, err := readCount(r)
+			if err != nil {
+				return nil, size, err
+			}
+			for j := uint64(0); j < items; j++ {
+				if _, err := readBytes(r); err != nil {
+					return nil, size, err
+				}
+			}
+		}
+		witnessLen += before - r.Len()
+	}
+	if err := binary.Read(r, binary.LittleEndian, &msg.LockTime); err != nil {
+		return nil, size, err
+	}
+	size.Total = int64(start - r.Len())
+	size.Base = size.Total - int64(witnessLen)
+	return msg, size, nil
+}
+
+// readCount reads a var int, which can not exceed the bytes left since
+// every counted item takes at least one.
+func readCount(r *bytes.Reader) (uint64, error) {
+	n, err := btcwire.ReadVarInt(r, 0)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, ErrBadSerialization
+	}
+	return n, nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
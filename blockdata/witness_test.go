@@ -0,0 +1,115 @@
+package blockdata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+const (
+	testTxVersion = "01000000"
+	testTxInputs  = "01" + "1111111111111111111111111111111111111111111111111111111111111111" + "02000000" + "00" + "ffffffff"
+	//One P2WPKH output of 0.001 BTC.
+	testTxOutputs  = "01" + "a086010000000000" + "16" + "00142222222222222222222222222222222222222222"
+	testTxWitness  = "02" + "03aabbcc" + "02dddd"
+	testTxLockTime = "00000000"
+)
+
+func testTx(t *testing.T, witness bool) []byte {
+	s := testTxVersion + testTxInputs + testTxOutputs + testTxLockTime
+	if witness {
+		s = testTxVersion + "0001" + testTxInputs + testTxOutputs + testTxWitness + testTxLockTime
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeMsgTx01(t *testing.T) {
+	legacy, legacySize, err := DecodeMsgTx(testTx(t, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacySize != (WireSize{Base: 82, Total: 82}) || legacySize.Vsize() != 82 {
+		t.Errorf("Legacy tx size %+v.", legacySize)
+	}
+	msg, size, err := DecodeMsgTx(testTx(t, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != (WireSize{Base: 82, Total: 92}) || size.Weight() != 338 || size.Vsize() != 85 {
+		t.Errorf("Witness tx size %+v, weight %d, vsize %d.", size, size.Weight(), size.Vsize())
+	}
+	if len(msg.TxIn) != 1 || msg.TxIn[0].PreviousOutPoint.Index != 2 || msg.TxIn[0].Sequence != 0xffffffff {
+		t.Errorf("Unexpected inputs %+v.", msg.TxIn)
+	}
+	if len(msg.TxOut) != 1 || msg.TxOut[0].Value != 100000 || len(msg.TxOut[0].PkScript) != 22 {
+		t.Errorf("Unexpected outputs %+v.", msg.TxOut)
+	}
+	//The witness is dropped, so the txid is that of the legacy serialization.
+	var buf bytes.Buffer
+	msg.Serialize(&buf)
+	if !bytes.Equal(buf.Bytes(), testTx(t, false)) || legacy.SerializeSize() != 82 {
+		t.Errorf("Stripped tx %x.", buf.Bytes())
+	}
+}
+
+func TestDecodeMsgTxNoInputs01(t *testing.T) {
+	//With one output the count could not be told from the witness flag.
+	output := testTxOutputs[2:]
+	raw, _ := hex.DecodeString(testTxVersion + "00" + "02" + output + output + testTxLockTime)
+	msg, size, err := DecodeMsgTx(raw)
+	if err != nil || len(msg.TxIn) != 0 || len(msg.TxOut) != 2 || size.Total != int64(len(raw)) {
+		t.Errorf("Tx without inputs: %v %+v.", err, size)
+	}
+}
+
+func TestDecodeMsgTxInvalid01(t *testing.T) {
+	raw := testTx(t, true)
+	for name, data := range map[string][]byte{
+		"truncated": raw[:len(raw)-1],
+		"trailing":  append(append([]byte{}, raw...), 0),
+		//Claims more inputs than there are bytes.
+		"count": {1, 0, 0, 0, 0xfe, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		if _, _, err := DecodeMsgTx(data); err == nil {
+			t.Errorf("%s: decoded.", name)
+		}
+	}
+}
+
+func TestDecodeMsgBlock01(t *testing.T) {
+	var raw bytes.Buffer
+	raw.Write(make([]byte, 80))
+	raw.WriteByte(2)
+	raw.Write(testTx(t, false))
+	raw.Write(testTx(t, true))
+	msg, size, sizes, err := DecodeMsgBlock(raw.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Transactions) != 2 || len(sizes) != 2 || sizes[1].Total != 92 {
+		t.Fatalf("Unexpected txs %d, sizes %+v.", len(msg.Transactions), sizes)
+	}
+	if size != (WireSize{Base: 81 + 82 + 82, Total: 81 + 82 + 92}) {
+		t.Errorf("Block size %+v.", size)
+	}
+}
+
+func TestNewFromString01(t *testing.T) {
+	tx := new(ModelTx)
+	if err := tx.NewFromString(hex.EncodeToString(testTx(t, true))); err != nil || tx.Msg == nil || tx.Size != 85 {
+		t.Errorf("Got %v, size %d.", err, tx.Size)
+	}
+	for _, s := range []string{"zz", testTxVersion + "01"} {
+		tx := new(ModelTx)
+		if err := tx.NewFromString(s); err == nil || tx.Msg != nil {
+			t.Errorf("%s decoded.", s)
+		}
+		if err := tx.UpdateInOutFromString(s); err == nil || tx.Msg != nil {
+			t.Errorf("%s decoded.", s)
+		}
+	}
+}
@@ -18,13 +18,65 @@ type Configuration struct {
 	Explorer_password string
 
 	//RPC config
-	Rpc_host     string
-	Rpc_port     int
-	Rpc_user     string
-	Rpc_password string
+	Rpc_host        string
+	Rpc_port        int
+	Rpc_user        string
+	Rpc_password    string
+	Rpc_cookie_file string //bitcoind .cookie, used instead of Rpc_user and Rpc_password
+	Rpc_tls         bool
+	Rpc_tls_ca_file string
+	Rpc_wallet      string
+	Rpc_socket      string //Unix socket, overrides Rpc_host and Rpc_port
+	Rpc_proxy       string
+	Rpc_timeout     int //Seconds per call, default 30
+	Rpc_retries     int //Retries of transient failures, default 5
+	Rpc_batch_size  int //Calls per JSON-RPC batch, default 100
+	//1 fetches txs with getrawtransaction and needs -txindex,
+	//2 fetches whole blocks with getblock verbosity 2 (bitcoind 0.15+)
+	Rpc_block_verbosity int
+	//Extra bitcoind backends, tried in order when one fails. When set the
+	//Rpc_host to Rpc_proxy settings above are not used.
+	Rpc_nodes []RpcNode
+	//Ask every node for the hash of each new block before inserting it
+	Rpc_cross_check bool
+
+	//P2P config, host:port of a node to sync from with -p2p
+	P2p_peer    string
+	P2p_testnet bool
+
+	//ZMQ endpoints of bitcoind's -zmqpub* options, e.g. tcp://127.0.0.1:28332
+	Zmq_rawblock  string
+	Zmq_rawtx     string
+	Zmq_hashblock string
+	Zmq_hashtx    string
+	Zmq_sequence  string
 
 	//Block data file config
 	Block_data_dir string
+
+	//Sync config, bulk inserts are used while the DB is further than this from the tip
+	Bulk_tip_distance int64
+
+	//Memory for unspent outputs kept while resolving spends, in MB
+	Utxo_cache_mb int64
+
+	//Let webhooks POST to loopback and private network addresses
+	Webhook_allow_private bool
+}
+
+// RpcNode is one entry of Rpc_nodes, with the same meaning as the Rpc_*
+// settings of Configuration.
+type RpcNode struct {
+	Host        string
+	Port        int
+	User        string
+	Password    string
+	Cookie_file string
+	Tls         bool
+	Tls_ca_file string
+	Wallet      string
+	Socket      string
+	Proxy       string
 }
 
 func InitConfiguration(fname string) (Configuration, error) {
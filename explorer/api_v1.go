@@ -3,11 +3,10 @@ package explorer
 import (
 	. "Assange/blockdata"
 	//. "Assange/util"
+	"database/sql"
 	"encoding/hex"
-	"encoding/json"
 	"github.com/conformal/btcscript"
 	"github.com/go-martini/martini"
-	"net/http"
 )
 
 type BlockV1 struct {
@@ -23,159 +22,328 @@ type BlockV1 struct {
 	Txn        []string `json:"transaction"`
 }
 
+type AddressV1 struct {
+	Address string `json:"address"`
+}
+
 type BalanceV1 struct {
 	Address string `json:"address"`
 	Balance int64  `json:"balance"`
 }
 
 type TxV1 struct {
-	Hash     string     `json:"hash"`
-	Ver      int32      `json:"version"`
-	LockTime uint32     `json:"lock_time"`
-	Txin     []*TxinV1  `json:"input"`
-	Txout    []*TxoutV1 `json:"output"`
-	Block    []string   `json:"block"`
+	Hash     string `json:"hash"`
+	Ver      int32  `json:"version"`
+	LockTime uint32 `json:"lock_time"`
+	//Null unless the values of all inputs are known
+	Fee   *int64     `json:"fee"`
+	Txin  []*TxinV1  `json:"input"`
+	Txout []*TxoutV1 `json:"output"`
+	Block []string   `json:"block"`
+	//"confirmed", "unconfirmed", or "decoded" for /decode
+	Status        string `json:"status"`
+	Confirmations int64  `json:"confirmations"`
+	//Block context, null while unconfirmed
+	BlockHeight *int64 `json:"block_height"`
+	BlockTime   *int64 `json:"block_time"`
+	BlockIndex  *int64 `json:"block_index"`
+	//When the tx was first seen in the mempool, null once confirmed
+	FirstSeen *int64 `json:"first_seen"`
 }
 
 type TxinV1 struct {
 	Sequence uint32
 	Script   string
+	Asm      string
+	//Spent output, unset on coinbase inputs
+	PrevHash  string
+	PrevIndex int64
+	//Coinbase inputs carry arbitrary data instead of a script
+	IsCoinbase   bool
+	CoinbaseData string
+	//Of the spent output, null when it is not indexed
+	Value     *int64
+	Addresses []string
 }
 
 type TxoutV1 struct {
-	Value  int64
-	Script string
-	Index  int64
-	Type   btcscript.ScriptClass
-	Spent  bool
+	Value     int64
+	Script    string
+	Asm       string
+	Index     int64
+	Type      string
+	Addresses []string
+	ReqSig    int
+	Spent     bool
 }
 
-func ApiBlockV1(params martini.Params) (int, string) {
-	return http.StatusOK, GetBlockV1(params["hashid"])
+func ApiBlockV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(GetBlockV1(params["hashid"]))
 }
 
-func ApiTxV1(params martini.Params) (int, string) {
-	return http.StatusOK, GetTxV1(params["hashid"])
+func ApiTxV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(GetTxV1(params["hashid"]))
 }
 
-func ApiAddressV1(params martini.Params) (int, string) {
-	return http.StatusOK, params["addr"]
+func ApiAddressV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(GetAddressV1(params["addr"]))
 }
 
-func ApiBalanceV1(params martini.Params) (int, string) {
-	return http.StatusOK, GetBalanceV1(params["addr"])
+func ApiBalanceV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(GetBalanceV1(params["addr"]))
 }
 
-func GetBlockV1(hash string) string {
-	var block = new(BlockV1)
-	var blockBuff []*ModelBlock
+// hashHex is where stored hashes are turned back into hex, unset hashes become "".
+func hashHex(h Hash) string {
+	if h.IsZero() {
+		return ""
+	}
+	return h.String()
+}
 
-	oldLen := len(blockBuff)
-	_, err := dbmap.Select(&blockBuff, "select * from block where Hash=?", hash)
+func GetBlockV1(hashStr string) (string, error) {
+	hash, err := NewHashFromStr(hashStr)
+	if err != nil {
+		return "", errBadRequest("invalid_hash", "Invalid block hash.")
+	}
+	mBlock, err := queries.BlockByHash(hash)
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
-	}
-	if oldLen == len(blockBuff) {
-		log.Error("Block not found. Hash:%s.", hash)
-		return "Error"
-	}
-	block.Hash = blockBuff[0].Hash
-	block.Height = blockBuff[0].Height
-	block.Ver = blockBuff[0].Ver
-	block.Time = blockBuff[0].Time.Unix()
-	block.PrevHash = blockBuff[0].PrevHash
-	block.NextHash = blockBuff[0].NextHash
-	block.Nonce = blockBuff[0].Nonce
-	block.Bits = blockBuff[0].Bits
-	block.MerkleRoot = blockBuff[0].MerkleRoot
-
-	var txHash []string
-	_, err = dbmap.Select(&txHash, "select Hash from tx where Id in (select TxId from blocktx where BlockId=?)", blockBuff[0].Id)
+		return "", errDb(err, "Block")
+	}
+	return blockV1String(mBlock)
+}
+
+func blockV1String(mBlock *ModelBlock) (string, error) {
+	var block = new(BlockV1)
+	block.Hash = hashHex(mBlock.Hash)
+	block.Height = mBlock.Height
+	block.Ver = mBlock.Ver
+	block.Time = mBlock.Time.Unix()
+	block.PrevHash = hashHex(mBlock.PrevHash)
+	block.NextHash = hashHex(mBlock.NextHash)
+	block.Nonce = mBlock.Nonce
+	block.Bits = mBlock.Bits
+	block.MerkleRoot = hashHex(mBlock.MerkleRoot)
+
+	hashes, err := queries.TxHashesByBlock(mBlock.Id)
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+		return "", errDb(err, "Block")
+	}
+	for _, hash := range hashes {
+		block.Txn = append(block.Txn, hashHex(hash))
 	}
-	block.Txn = txHash
+	return marshalV1(block)
+}
 
-	jsonBytes, err := json.MarshalIndent(block, "", "    ")
+func GetAddressV1(addr string) (string, error) {
+	addr, err := ParseAddress(addr)
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+		return "", errBadRequest("invalid_address", "Invalid address.")
 	}
-	return string(jsonBytes)
+	return marshalV1(&AddressV1{Address: addr})
 }
 
-func GetBalanceV1(addr string) string {
+// GetBalanceV1 answers 0 for valid addresses which were never paid.
+func GetBalanceV1(addr string) (string, error) {
 	var balanceMap = new(BalanceV1)
-	balance, err := dbmap.SelectInt("select Balance from address where Address=?", addr)
+	addr, err := ParseAddress(addr)
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+		return "", errBadRequest("invalid_address", "Invalid address.")
+	}
+	address, err := queries.AddressByAddress(addr)
+	if err == sql.ErrNoRows {
+		address = &ModelAddress{Address: addr}
+	} else if err != nil {
+		return "", errDb(err, "Address")
 	}
 	balanceMap.Address = addr
-	balanceMap.Balance = balance
-	jsonBytes, err := json.MarshalIndent(balanceMap, "", "    ")
+	balanceMap.Balance = address.Balance
+	return marshalV1(balanceMap)
+}
+
+// prevout is what an input spends, as far as it is known.
+type prevout struct {
+	value     int64
+	addresses []string
+}
+
+// lookupPrevout finds the output spent by in, nil when it is not indexed.
+func lookupPrevout(in *ModelTxin) (*prevout, error) {
+	if in.IsCoinbase {
+		return nil, nil
+	}
+	out, err := queries.TxoutByOutpoint(in.PrevOutHash, in.PrevOutIndex)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := queries.AddressesByTxout(out.Id)
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+		return nil, err
 	}
-	return string(jsonBytes)
+	return &prevout{value: out.Value, addresses: addresses}, nil
 }
 
-func GetTxV1(hashid string) string {
-	var txMap = new(TxV1)
-	var tx = new(ModelTx)
+var scriptTypeNames = map[btcscript.ScriptClass]string{
+	btcscript.NonStandardTy: "nonstandard",
+	btcscript.PubKeyTy:      "p2pk",
+	btcscript.PubKeyHashTy:  "p2pkh",
+	btcscript.ScriptHashTy:  "p2sh",
+	btcscript.MultiSigTy:    "multisig",
+	btcscript.NullDataTy:    "nulldata",
+}
 
-	err := dbmap.SelectOne(&tx, "select * from tx where Hash=?", hashid)
-	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+// scriptTypeName names an output script type. btcscript predates segwit,
+// so witness programs, which it calls nonstandard, are told apart here.
+func scriptTypeName(class btcscript.ScriptClass, script []byte) string {
+	if version, program, ok := WitnessProgram(script); ok && class == btcscript.NonStandardTy {
+		switch {
+		case version == 0 && len(program) == 20:
+			return "witness_v0_keyhash"
+		case version == 0 && len(program) == 32:
+			return "witness_v0_scripthash"
+		case version == 1 && len(program) == 32:
+			return "witness_v1_taproot"
+		case version > 0:
+			return "witness_unknown"
+		}
+	}
+	if name, ok := scriptTypeNames[class]; ok {
+		return name
 	}
-	txMap.Hash = tx.Hash
+	return "nonstandard"
+}
+
+func disasm(script []byte) string {
+	//On invalid scripts the disassembly up to the error is kept.
+	asm, _ := btcscript.DisasmString(script)
+	return asm
+}
+
+// newTxV1 builds the API view of a tx with its Txins and Txouts loaded.
+// Prevouts missing from known are looked up in the DB.
+func newTxV1(tx *ModelTx, known map[int]*prevout) (*TxV1, error) {
+	txMap := new(TxV1)
+	txMap.Hash = hashHex(tx.Hash)
 	txMap.Ver = tx.Ver
 	txMap.LockTime = tx.LockTime
 
-	var inBuff []*ModelTxin
-	_, err = dbmap.Select(&inBuff, "select * from txin where InTxHash=?", hashid)
-	if err != nil {
-		log.Error(err.Error())
-		return "Error"
-	}
-	for _, in := range inBuff {
+	var fee int64
+	feeKnown := !tx.IsCoinbase
+	for idx, in := range tx.Txins {
 		txinMap := new(TxinV1)
 		txinMap.Sequence = in.Sequence
 		txinMap.Script = hex.EncodeToString(in.InScript)
+		if in.IsCoinbase {
+			txinMap.IsCoinbase = true
+			txinMap.CoinbaseData = txinMap.Script
+		} else {
+			txinMap.Asm = disasm(in.InScript)
+			txinMap.PrevHash = hashHex(in.PrevOutHash)
+			txinMap.PrevIndex = in.PrevOutIndex
+		}
+		prev, ok := known[idx]
+		if !ok {
+			var err error
+			if prev, err = lookupPrevout(in); err != nil {
+				return nil, err
+			}
+		}
+		if prev != nil {
+			value := prev.value
+			txinMap.Value = &value
+			txinMap.Addresses = prev.addresses
+			fee += value
+		} else {
+			feeKnown = false
+		}
 		txMap.Txin = append(txMap.Txin, txinMap)
 	}
 
-	var outBuff []*ModelTxout
-	_, err = dbmap.Select(&outBuff, "select * from txout where OutTxHash=?", hashid)
-	if err != nil {
-		log.Error(err.Error())
-		return "Error"
-	}
-	for _, out := range outBuff {
+	for _, out := range tx.Txouts {
 		txoutMap := new(TxoutV1)
-		txoutMap.Type = out.Type
+		txoutMap.Type = scriptTypeName(out.Type, out.OutScript)
+		txoutMap.Addresses = out.Addresses
+		txoutMap.ReqSig = out.ReqSig
 		txoutMap.Spent = out.Spent
 		txoutMap.Value = out.Value
 		txoutMap.Script = hex.EncodeToString(out.OutScript)
+		txoutMap.Asm = disasm(out.OutScript)
 		txoutMap.Index = out.OutIndex
 		txMap.Txout = append(txMap.Txout, txoutMap)
+		fee -= out.Value
+	}
+	if feeKnown {
+		txMap.Fee = &fee
+	}
+	return txMap, nil
+}
+
+func GetTxV1(hashid string) (string, error) {
+	hash, err := NewHashFromStr(hashid)
+	if err != nil {
+		return "", errBadRequest("invalid_hash", "Invalid tx hash.")
+	}
+	tx, err := queries.TxByHash(hash)
+	if err != nil {
+		return "", errDb(err, "Tx")
 	}
 
-	var mBlock = new(ModelBlock)
-	err = dbmap.SelectOne(mBlock, "select Hash from block where Id in (select BlockId from blocktx where TxId=? )", tx.Id)
+	if tx.Txins, err = queries.TxinsByTx(tx.Id); err != nil {
+		return "", errDb(err, "Tx")
+	}
+	if tx.Txouts, err = queries.TxoutsByTx(tx.Id); err != nil {
+		return "", errDb(err, "Tx")
+	}
+	for _, out := range tx.Txouts {
+		//Also covers outputs which are not extracted yet.
+		out.Classify()
+	}
+	txMap, err := newTxV1(tx, nil)
+	if err != nil {
+		return "", errDb(err, "Tx")
+	}
+
+	//Unconfirmed txs have no block yet.
+	mBlock, err := queries.BlockByTx(tx.Id)
+	if err == sql.ErrNoRows {
+		setUnconfirmed(txMap, tx)
+		return marshalV1(txMap)
+	}
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+		return "", errDb(err, "Block")
 	}
-	txMap.Block = append(txMap.Block, mBlock.Hash)
-	jsonBytes, err := json.MarshalIndent(txMap, "", "    ")
+	index, err := queries.TxIndexInBlock(mBlock.Id, tx.Id)
 	if err != nil {
-		log.Error(err.Error())
-		return "Error"
+		return "", errDb(err, "Block")
+	}
+	tipHeight, err := dbmap.SelectInt("select coalesce(max(Height), 0) from block")
+	if err != nil {
+		return "", errDb(err, "Block")
+	}
+	setConfirmed(txMap, mBlock, index, tipHeight)
+	return marshalV1(txMap)
+}
+
+func setUnconfirmed(txMap *TxV1, tx *ModelTx) {
+	txMap.Status = "unconfirmed"
+	firstSeen := tx.ReceivedTime.Unix()
+	txMap.FirstSeen = &firstSeen
+}
+
+// setConfirmed fills the block context of a tx at index in mBlock.
+func setConfirmed(txMap *TxV1, mBlock *ModelBlock, index int64, tipHeight int64) {
+	height, blockTime := mBlock.Height, mBlock.Time.Unix()
+	txMap.Status = "confirmed"
+	txMap.Block = append(txMap.Block, hashHex(mBlock.Hash))
+	txMap.BlockHeight = &height
+	txMap.BlockTime = &blockTime
+	txMap.BlockIndex = &index
+	txMap.Confirmations = tipHeight - height + 1
+	//The tip may have been read before the block got inserted.
+	if txMap.Confirmations < 1 {
+		txMap.Confirmations = 1
 	}
-	return string(jsonBytes)
 }
@@ -0,0 +1,91 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"encoding/hex"
+	"github.com/conformal/btcscript"
+	"testing"
+	"time"
+)
+
+func TestScriptTypeName01(t *testing.T) {
+	cases := []struct {
+		class  btcscript.ScriptClass
+		script string
+		name   string
+	}{
+		{btcscript.PubKeyHashTy, "76a914128004ff2fcaf13b2b91eb654b1dc2b674f7ec6188ac", "p2pkh"},
+		{btcscript.ScriptHashTy, "a914e895dfe6ca7affb5f066685ac93eaa138297e13887", "p2sh"},
+		{btcscript.MultiSigTy, "", "multisig"},
+		{btcscript.NullDataTy, "6a0568656c6c6f", "nulldata"},
+		{btcscript.NonStandardTy, "0014751e76e8199196d454941c45d1b3a323f1433bd6", "witness_v0_keyhash"},
+		{btcscript.NonStandardTy, "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262", "witness_v0_scripthash"},
+		{btcscript.NonStandardTy, "5120a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c", "witness_v1_taproot"},
+		{btcscript.NonStandardTy, "6002751e", "witness_unknown"},
+		{btcscript.NonStandardTy, "0015751e76e8199196d454941c45d1b3a323f1433bd6", "nonstandard"},
+		{btcscript.NonStandardTy, "", "nonstandard"},
+	}
+	for _, c := range cases {
+		script, _ := hex.DecodeString(c.script)
+		if name := scriptTypeName(c.class, script); name != c.name {
+			t.Errorf("%s: got %s, expected %s.", c.script, name, c.name)
+		}
+	}
+}
+
+func TestTxinV1Prevout01(t *testing.T) {
+	prevHash, _ := NewHashFromStr("0437cd7f8525ceed2324359c2d0ba26006d92d856a9c20fa0241106ee5a597c9")
+	tx := new(ModelTx)
+	tx.Txins = []*ModelTxin{{InScript: []byte{0x01, 0x02}, PrevOutHash: prevHash, PrevOutIndex: 1}}
+	tx.Txouts = []*ModelTxout{{Value: 900}}
+	txMap, err := newTxV1(tx, map[int]*prevout{0: {value: 1000, addresses: []string{"12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := txMap.Txin[0]
+	if in.PrevHash != prevHash.String() || in.PrevIndex != 1 || in.IsCoinbase || *in.Value != 1000 || len(in.Addresses) != 1 {
+		t.Errorf("Unexpected input: %+v.", in)
+	}
+	if *txMap.Fee != 100 {
+		t.Errorf("Got fee %d, expected 100.", *txMap.Fee)
+	}
+}
+
+func TestTxinV1Coinbase01(t *testing.T) {
+	tx := new(ModelTx)
+	tx.IsCoinbase = true
+	tx.Txins = []*ModelTxin{{InScript: []byte{0x03, 0x5b, 0x7a, 0x03}, PrevOutIndex: 0xffffffff, IsCoinbase: true}}
+	txMap, err := newTxV1(tx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := txMap.Txin[0]
+	if !in.IsCoinbase || in.CoinbaseData != "035b7a03" || in.PrevHash != "" || in.PrevIndex != 0 {
+		t.Errorf("Unexpected coinbase input: %+v.", in)
+	}
+}
+
+func TestSetConfirmed01(t *testing.T) {
+	block := &ModelBlock{Height: 100, Time: time.Unix(1231006505, 0)}
+	txMap := new(TxV1)
+	setConfirmed(txMap, block, 3, 105)
+	if txMap.Status != "confirmed" || txMap.Confirmations != 6 || *txMap.BlockHeight != 100 || *txMap.BlockTime != 1231006505 || *txMap.BlockIndex != 3 {
+		t.Errorf("Unexpected block context: %+v.", txMap)
+	}
+	if txMap.FirstSeen != nil || len(txMap.Block) != 1 {
+		t.Errorf("Unexpected block context: %+v.", txMap)
+	}
+	txMap = new(TxV1)
+	setConfirmed(txMap, block, 0, 99)
+	if txMap.Confirmations != 1 {
+		t.Errorf("Got %d confirmations with a stale tip, expected 1.", txMap.Confirmations)
+	}
+}
+
+func TestSetUnconfirmed01(t *testing.T) {
+	txMap := new(TxV1)
+	setUnconfirmed(txMap, &ModelTx{ReceivedTime: time.Unix(1500000000, 0)})
+	if txMap.Status != "unconfirmed" || txMap.Confirmations != 0 || txMap.BlockHeight != nil || *txMap.FirstSeen != 1500000000 {
+		t.Errorf("Unexpected status: %+v.", txMap)
+	}
+}
@@ -0,0 +1,142 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"github.com/go-martini/martini"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultBlocksLimit = 10
+	maxBlocksLimit     = 100
+)
+
+type BlockSummaryV1 struct {
+	Hash     string `json:"hash"`
+	Height   int64  `json:"height"`
+	Time     int64  `json:"time"`
+	TxCount  int64  `json:"tx_count"`
+	Size     int64  `json:"size"`
+	Weight   int64  `json:"weight"`
+	TotalOut int64  `json:"total_out"`
+	//Null while some inputs of the block are not resolved
+	Fees *int64 `json:"fees"`
+}
+
+type BlocksV1 struct {
+	Blocks []*BlockSummaryV1 `json:"blocks"`
+	//Pass as before to get the next page, -1 on the last page
+	Next int64 `json:"next"`
+}
+
+type TipV1 struct {
+	Hash   string `json:"hash"`
+	Height int64  `json:"height"`
+	Time   int64  `json:"time"`
+}
+
+var errBlocksParams = errBadRequest("invalid_request", "limit must be 1 to 100, before a block height.")
+
+func ApiBlockHeightV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(GetBlockHeightV1(params["n"]))
+}
+
+func ApiBlocksV1(req *http.Request, rid requestId) (int, string) {
+	query := req.URL.Query()
+	return rid.respond(GetBlocksV1(query.Get("limit"), query.Get("before")))
+}
+
+func ApiTipV1(rid requestId) (int, string) {
+	return rid.respond(GetTipV1())
+}
+
+func GetBlockHeightV1(heightStr string) (string, error) {
+	height, err := strconv.ParseInt(heightStr, 10, 64)
+	if err != nil || height < 0 {
+		return "", errBadRequest("invalid_height", "Invalid block height.")
+	}
+	mBlock, err := queries.BlockByHeight(height)
+	if err != nil {
+		return "", errDb(err, "Block")
+	}
+	return blockV1String(mBlock)
+}
+
+// parseBlocksParams reads limit and before, before being -1 when the list
+// starts at the tip.
+func parseBlocksParams(limitStr string, beforeStr string) (int64, int64, error) {
+	return parsePageParams(limitStr, beforeStr, errBlocksParams)
+}
+
+// parsePageParams reads the limit and before of a list paged newest first,
+// before being -1 for the first page. Bad values are answered with errParams.
+func parsePageParams(limitStr string, beforeStr string, errParams *ApiError) (int64, int64, error) {
+	limit, before := int64(defaultBlocksLimit), int64(-1)
+	var err error
+	if limitStr != "" {
+		if limit, err = strconv.ParseInt(limitStr, 10, 64); err != nil || limit < 1 || limit > maxBlocksLimit {
+			return 0, 0, errParams
+		}
+	}
+	if beforeStr != "" {
+		if before, err = strconv.ParseInt(beforeStr, 10, 64); err != nil || before < 0 {
+			return 0, 0, errParams
+		}
+	}
+	return limit, before, nil
+}
+
+func GetBlocksV1(limitStr string, beforeStr string) (string, error) {
+	limit, before, err := parseBlocksParams(limitStr, beforeStr)
+	if err != nil {
+		return "", err
+	}
+	var mBlocks []*ModelBlock
+	if before < 0 {
+		_, err = dbmap.Select(&mBlocks, "select * from block order by Height desc limit ?", limit)
+	} else {
+		_, err = dbmap.Select(&mBlocks, "select * from block where Height<? order by Height desc limit ?", before, limit)
+	}
+	if err != nil {
+		return "", errDb(err, "Block")
+	}
+
+	blocks := &BlocksV1{Blocks: []*BlockSummaryV1{}, Next: -1}
+	for _, mBlock := range mBlocks {
+		stats, err := BlockStatsOf(dbmap, mBlock.Id)
+		if err != nil {
+			return "", errDb(err, "Block")
+		}
+		blocks.Blocks = append(blocks.Blocks, newBlockSummary(mBlock, stats))
+	}
+	if n := len(mBlocks); int64(n) == limit && mBlocks[n-1].Height > 0 {
+		blocks.Next = mBlocks[n-1].Height
+	}
+	return marshalV1(blocks)
+}
+
+func newBlockSummary(mBlock *ModelBlock, stats *BlockStats) *BlockSummaryV1 {
+	summary := &BlockSummaryV1{
+		Hash:     hashHex(mBlock.Hash),
+		Height:   mBlock.Height,
+		Time:     mBlock.Time.Unix(),
+		TxCount:  stats.TxCount,
+		Size:     mBlock.Size,
+		Weight:   mBlock.Weight,
+		TotalOut: stats.TotalOut,
+	}
+	if fee, ok := stats.Fee(); ok {
+		summary.Fees = &fee
+	}
+	return summary
+}
+
+func GetTipV1() (string, error) {
+	var mBlock = new(ModelBlock)
+	err := dbmap.SelectOne(mBlock, "select * from block order by Height desc limit 1")
+	if err != nil {
+		return "", errDb(err, "Block")
+	}
+	return marshalV1(&TipV1{Hash: hashHex(mBlock.Hash), Height: mBlock.Height, Time: mBlock.Time.Unix()})
+}
@@ -0,0 +1,41 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"testing"
+)
+
+func TestParseBlocksParams01(t *testing.T) {
+	cases := []struct {
+		limit  string
+		before string
+		ok     bool
+		l, b   int64
+	}{
+		{"", "", true, defaultBlocksLimit, -1},
+		{"5", "1000", true, 5, 1000},
+		{"100", "0", true, 100, 0},
+		{"0", "", false, 0, 0},
+		{"101", "", false, 0, 0},
+		{"x", "", false, 0, 0},
+		{"", "-1", false, 0, 0},
+	}
+	for _, c := range cases {
+		l, b, err := parseBlocksParams(c.limit, c.before)
+		if (err == nil) != c.ok || l != c.l || b != c.b {
+			t.Errorf("%q %q: got %d %d %v.", c.limit, c.before, l, b, err)
+		}
+	}
+}
+
+func TestBlockSummaryFees01(t *testing.T) {
+	block := &ModelBlock{Height: 170}
+	summary := newBlockSummary(block, &BlockStats{TxCount: 2, TotalOut: 5000000000 + 1000000000, TotalIn: 1000010000, NonCbOut: 1000000000})
+	if summary.Fees == nil || *summary.Fees != 10000 || summary.TxCount != 2 {
+		t.Errorf("Unexpected summary: %+v.", summary)
+	}
+	summary = newBlockSummary(block, &BlockStats{TxCount: 2, Unresolved: 1})
+	if summary.Fees != nil {
+		t.Errorf("Fees of a block with unresolved inputs: %d.", *summary.Fees)
+	}
+}
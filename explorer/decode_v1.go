@@ -0,0 +1,83 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"Assange/raw"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// PSBTs carry the previous txs of their inputs, so allow more than for a
+// plain tx.
+const maxDecodeBodyBytes = 2000000
+
+func ApiDecodeV1(req *http.Request, rid requestId) (int, string) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxDecodeBodyBytes+1))
+	if err != nil || len(body) > maxDecodeBodyBytes {
+		return rid.respond("", errBodyTooLarge)
+	}
+	return rid.respond(DecodeV1Raw(bodyHex(req, body)))
+}
+
+// DecodeV1Raw shows a tx given as hex, or a PSBT given as hex or base64,
+// like /tx does. Nothing is stored or relayed.
+func DecodeV1Raw(input string) (string, error) {
+	tx, known, err := decodeInput(input)
+	if err != nil {
+		return "", errBadRequest("invalid_tx", err.Error())
+	}
+	txMap, err := newTxV1(tx, known)
+	if err != nil {
+		return "", errDb(err, "Prevout")
+	}
+	txMap.Status = "decoded"
+	return marshalV1(txMap)
+}
+
+// decodeInput returns the decoded tx and, for PSBTs, the prevouts the PSBT
+// supplies itself.
+func decodeInput(input string) (*ModelTx, map[int]*prevout, error) {
+	data, err := hex.DecodeString(input)
+	if err != nil {
+		//Base64 is the usual text form of PSBTs.
+		b64, b64Err := base64.StdEncoding.DecodeString(input)
+		if b64Err != nil || !raw.IsPsbt(b64) {
+			return nil, nil, err
+		}
+		data = b64
+	}
+	if !raw.IsPsbt(data) {
+		tx, err := raw.NewTxFromRaw(data)
+		return tx, nil, err
+	}
+	psbt, err := raw.DecodePsbt(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newTxFromPsbt(psbt)
+}
+
+// newTxFromPsbt takes the final scriptSigs where the PSBT has them, so a
+// finalized PSBT shows as the tx that would be broadcast.
+func newTxFromPsbt(psbt *raw.Psbt) (*ModelTx, map[int]*prevout, error) {
+	known := make(map[int]*prevout)
+	for idx, in := range psbt.Inputs {
+		if in.FinalScriptSig != nil {
+			psbt.Tx.TxIn[idx].SignatureScript = in.FinalScriptSig
+		}
+		if in.Utxo != nil {
+			out := &ModelTxout{OutScript: in.Utxo.PkScript}
+			out.Classify()
+			known[idx] = &prevout{value: in.Utxo.Value, addresses: out.Addresses}
+		}
+	}
+	tx := new(ModelTx)
+	if err := tx.NewFromMsg(psbt.Tx); err != nil {
+		return nil, nil, err
+	}
+	tx.NewInOutFromMsg()
+	return tx, known, nil
+}
@@ -0,0 +1,68 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"Assange/raw"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+func testPsbtTx() *btcwire.MsgTx {
+	return &btcwire.MsgTx{
+		Version: 2,
+		TxIn: []*btcwire.TxIn{
+			{PreviousOutPoint: btcwire.OutPoint{Hash: btcwire.ShaHash{1}, Index: 0}},
+			{PreviousOutPoint: btcwire.OutPoint{Hash: btcwire.ShaHash{2}, Index: 3}},
+		},
+		TxOut: []*btcwire.TxOut{{Value: 60000, PkScript: []byte{0x51}}, {Value: 30000, PkScript: []byte{0x52}}},
+	}
+}
+
+func TestDecodePsbtFee01(t *testing.T) {
+	psbt := &raw.Psbt{
+		Tx: testPsbtTx(),
+		Inputs: []*raw.PsbtInput{
+			{Utxo: &btcwire.TxOut{Value: 50000}, FinalScriptSig: []byte{0x00}},
+			{Utxo: &btcwire.TxOut{Value: 45000}},
+		},
+	}
+	tx, known, err := newTxFromPsbt(psbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txMap, err := newTxV1(tx, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txMap.Fee == nil || *txMap.Fee != 5000 {
+		t.Errorf("Got fee %v, expected 5000.", txMap.Fee)
+	}
+	if txMap.Txin[0].Script != "00" || txMap.Txin[1].Script != "" {
+		t.Errorf("Final scriptSig not applied: %q %q.", txMap.Txin[0].Script, txMap.Txin[1].Script)
+	}
+	if *txMap.Txin[1].Value != 45000 || len(txMap.Txout) != 2 {
+		t.Errorf("Unexpected decode: %+v.", txMap)
+	}
+}
+
+func TestDecodeCoinbaseFee01(t *testing.T) {
+	tx := new(ModelTx)
+	tx.IsCoinbase = true
+	tx.Txins = []*ModelTxin{{IsCoinbase: true}}
+	tx.Txouts = []*ModelTxout{{Value: 5000000000}}
+	txMap, err := newTxV1(tx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txMap.Fee != nil || txMap.Txin[0].Value != nil {
+		t.Errorf("Coinbase has no fee or input value, got %v %v.", txMap.Fee, txMap.Txin[0].Value)
+	}
+}
+
+func TestDecodeInputInvalid01(t *testing.T) {
+	for _, input := range []string{"", "zz", "0100", "cHNidP8="} {
+		if _, _, err := decodeInput(input); err == nil {
+			t.Errorf("Expected an error decoding %q.", input)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package explorer
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-martini/martini"
+	"net"
+	"net/http"
+)
+
+// Longer request ids from clients are replaced.
+const maxRequestIdLength = 64
+
+// ApiError is a failure answered to the client with its HTTP status and a
+// stable code. Other errors are answered as internal errors.
+type ApiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
+type ErrorV1 struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id"`
+}
+
+// requestId identifies a request in error bodies and logs.
+type requestId string
+
+func errBadRequest(code string, message string) *ApiError {
+	return &ApiError{http.StatusBadRequest, code, message}
+}
+
+func errNotFound(message string) *ApiError {
+	return &ApiError{http.StatusNotFound, "not_found", message}
+}
+
+// errDb tells missing rows, reported as what not being found, from a
+// database which can not be reached and other failures.
+func errDb(err error, what string) error {
+	if err == sql.ErrNoRows {
+		return errNotFound(what + " not found.")
+	}
+	if _, ok := err.(net.Error); ok || err == driver.ErrBadConn {
+		return &ApiError{http.StatusServiceUnavailable, "db_unavailable", "The database can not be reached."}
+	}
+	return err
+}
+
+// apiHeaders runs before every handler. It sets the JSON content type and
+// the X-Request-Id header, keeping the client's id if it sent one.
+func apiHeaders(c martini.Context, w http.ResponseWriter, req *http.Request) {
+	id := req.Header.Get("X-Request-Id")
+	if id == "" || len(id) > maxRequestIdLength {
+		id = newRequestId()
+	}
+	w.Header().Set("X-Request-Id", id)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.Map(requestId(id))
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// respond turns the result of a Get*V1 function into a response.
+func (rid requestId) respond(body string, err error) (int, string) {
+	if err == nil {
+		return http.StatusOK, body
+	}
+	apiErr, ok := err.(*ApiError)
+	if !ok {
+		log.Error("Request %s: %s", rid, err.Error())
+		apiErr = &ApiError{http.StatusInternalServerError, "internal_error", "Internal error."}
+	} else if apiErr.Status >= http.StatusInternalServerError {
+		log.Error("Request %s: %s", rid, apiErr.Message)
+	}
+	jsonBytes, _ := json.MarshalIndent(&ErrorV1{Code: apiErr.Code, Message: apiErr.Message, RequestId: string(rid)}, "", "    ")
+	return apiErr.Status, string(jsonBytes)
+}
+
+func marshalV1(v interface{}) (string, error) {
+	jsonBytes, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+func ApiNotFoundV1(rid requestId) (int, string) {
+	return rid.respond("", errNotFound("No such API endpoint."))
+}
@@ -0,0 +1,45 @@
+package explorer
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestRespond01(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errDb(sql.ErrNoRows, "Block"), http.StatusNotFound, "not_found"},
+		{errDb(driver.ErrBadConn, "Block"), http.StatusServiceUnavailable, "db_unavailable"},
+		{errDb(&net.OpError{Op: "dial", Err: errors.New("refused")}, "Block"), http.StatusServiceUnavailable, "db_unavailable"},
+		{errDb(errors.New("Error 1146: Table doesn't exist"), "Block"), http.StatusInternalServerError, "internal_error"},
+		{errBadRequest("invalid_hash", "Invalid block hash."), http.StatusBadRequest, "invalid_hash"},
+	}
+	for _, c := range cases {
+		status, body := requestId("abc").respond("", c.err)
+		var e ErrorV1
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			t.Fatalf("Error body is no JSON: %s.", body)
+		}
+		if status != c.status || e.Code != c.code || e.RequestId != "abc" || e.Message == "" {
+			t.Errorf("%v: got %d %+v, expected %d %s.", c.err, status, e, c.status, c.code)
+		}
+	}
+	if status, body := requestId("abc").respond("{}", nil); status != http.StatusOK || body != "{}" {
+		t.Errorf("Success answered with %d %s.", status, body)
+	}
+}
+
+func TestErrDbNotFound01(t *testing.T) {
+	err, ok := errDb(sql.ErrNoRows, "Tx").(*ApiError)
+	if !ok || err.Message != "Tx not found." {
+		t.Errorf("Got %v.", err)
+	}
+}
@@ -1,6 +1,7 @@
 package explorer
 
 import (
+	. "Assange/blockdata"
 	. "Assange/config"
 	. "Assange/logging"
 	"database/sql"
@@ -12,6 +13,7 @@ import (
 
 var ExplorerServer *martini.Martini
 var dbmap *gorp.DbMap
+var queries *Queries
 var log = GetLogger("Explorer", DEBUG)
 
 func InitExplorerServer(config Configuration) {
@@ -22,15 +24,35 @@ func InitExplorerServer(config Configuration) {
 	}
 	log.Debug("Init explorer.")
 	dbmap = &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	InitTables(dbmap)
+	queries, err = PrepareQueries(db)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
 
 	ExplorerServer = martini.New()
+	ExplorerServer.Use(apiHeaders)
 
 	r := martini.NewRouter()
 
 	r.Get(`/api/v1/block/:hashid`, ApiBlockV1)
+	r.Get(`/api/v1/block-height/:n`, ApiBlockHeightV1)
+	r.Get(`/api/v1/blocks`, ApiBlocksV1)
+	r.Get(`/api/v1/tip`, ApiTipV1)
 	r.Get(`/api/v1/tx/:hashid`, ApiTxV1)
+	r.Post(`/api/v1/tx/send`, ApiSendTxV1)
+	r.Post(`/api/v1/decode`, ApiDecodeV1)
 	r.Get(`/api/v1/address/:addr`, ApiAddressV1)
 	r.Get(`/api/v1/balance/:addr`, ApiBalanceV1)
+	r.Get(`/api/v1/fees`, ApiFeesV1)
+	r.Get(`/api/v1/search`, ApiSearchV1)
+	r.Get(`/api/v1/ws`, ApiWsV1)
+	r.Post(`/api/v1/webhooks`, ApiCreateWebhookV1)
+	r.Get(`/api/v1/webhooks/:id`, ApiWebhookV1)
+	r.Delete(`/api/v1/webhooks/:id`, ApiDeleteWebhookV1)
+	r.Get(`/api/v1/webhooks/:id/deliveries`, ApiWebhookDeliveriesV1)
+	r.NotFound(ApiNotFoundV1)
 
 	ExplorerServer.Action(r.Handle)
 	ExplorerServer.RunOnAddr(":8000")
@@ -0,0 +1,238 @@
+package explorer
+
+import (
+	"Assange/bitcoinrpc"
+	. "Assange/blockdata"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//Recent blocks whose fee rates are taken into account.
+	feeHistoryBlocks = 12
+	//Block limit in virtual bytes, 4M weight units since segwit. The mempool
+	//is cut into blocks of this size.
+	maxBlockSize = 1000000
+	//bitcoind's default -minrelaytxfee, in satoshi per byte.
+	minRelayFeeRate = 1.0
+	//A block's entry rate is the fee rate below which this share of its bytes were paid.
+	blockEntryShare = 0.1
+	//Longest time an answer is reused while the index does not change. Inputs
+	//resolved later and the node's estimates are picked up after it.
+	feesCacheTtl = 30 * time.Second
+)
+
+var feeTargets = []int64{1, 2, 3, 6, 12, 24}
+
+// Lower bounds of the histogram buckets, in satoshi per byte.
+var feeBuckets = []float64{1, 2, 3, 5, 8, 10, 15, 20, 30, 40, 50, 70, 100, 150, 200, 300, 500, 1000}
+
+// Fee rates are in satoshi per byte.
+type FeesV1 struct {
+	Estimates []*FeeEstimateV1 `json:"estimates"`
+	Blocks    []*BlockFeesV1   `json:"blocks"`
+	Mempool   *MempoolFeesV1   `json:"mempool"`
+}
+
+type FeeEstimateV1 struct {
+	Target  int64   `json:"target"`
+	FeeRate float64 `json:"fee_rate"`
+	//"mempool" when the mempool fills target blocks, "blocks" otherwise.
+	Source string `json:"source"`
+	//bitcoind's estimatesmartfee for comparison, null if it has none.
+	NodeFeeRate *float64 `json:"node_fee_rate"`
+}
+
+type BlockFeesV1 struct {
+	Height        int64   `json:"height"`
+	Hash          string  `json:"hash"`
+	TxCount       int     `json:"tx_count"`
+	MinFeeRate    float64 `json:"min_fee_rate"`
+	EntryFeeRate  float64 `json:"entry_fee_rate"`
+	MedianFeeRate float64 `json:"median_fee_rate"`
+	MaxFeeRate    float64 `json:"max_fee_rate"`
+}
+
+type MempoolFeesV1 struct {
+	TxCount   int            `json:"tx_count"`
+	Size      int64          `json:"size"`
+	TotalFee  int64          `json:"total_fee"`
+	Histogram []*FeeBucketV1 `json:"histogram"`
+}
+
+type FeeBucketV1 struct {
+	MinFeeRate float64 `json:"min_fee_rate"`
+	TxCount    int     `json:"tx_count"`
+	Size       int64   `json:"size"`
+}
+
+type byFeeRate []*TxFee
+
+func (s byFeeRate) Len() int           { return len(s) }
+func (s byFeeRate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byFeeRate) Less(i, j int) bool { return s[i].Rate() < s[j].Rate() }
+
+func ApiFeesV1(rid requestId) (int, string) {
+	return rid.respond(GetFeesV1())
+}
+
+// feesCache holds the last answer. Computing it scans the mempool and the
+// recent blocks, so concurrent requests wait for one computation.
+var feesCache struct {
+	sync.Mutex
+	version int64
+	time    time.Time
+	body    string
+}
+
+func GetFeesV1() (string, error) {
+	feesCache.Lock()
+	defer feesCache.Unlock()
+	version := IndexVersion()
+	if feesCache.body != "" && feesCache.version == version && time.Since(feesCache.time) < feesCacheTtl {
+		return feesCache.body, nil
+	}
+	body, err := computeFeesV1()
+	if err != nil {
+		return "", err
+	}
+	feesCache.version, feesCache.time, feesCache.body = version, time.Now(), body
+	return body, nil
+}
+
+func computeFeesV1() (string, error) {
+	fees := new(FeesV1)
+
+	var blocks []*ModelBlock
+	_, err := dbmap.Select(&blocks, "select * from block order by Height desc limit ?", feeHistoryBlocks)
+	if err != nil {
+		return "", errDb(err, "Block")
+	}
+	for _, block := range blocks {
+		txFees, err := TxFeesOfBlock(dbmap, block.Id)
+		if err != nil {
+			return "", errDb(err, "Block")
+		}
+		if len(txFees) == 0 {
+			continue
+		}
+		fees.Blocks = append(fees.Blocks, newBlockFees(block, txFees))
+	}
+
+	mempool, err := TxFeesOfMempool(dbmap)
+	if err != nil {
+		return "", errDb(err, "Mempool")
+	}
+	fees.Mempool = newMempoolFees(mempool)
+	fees.Estimates = estimateFees(fees.Blocks, mempool)
+
+	nodeFees, err := bitcoinrpc.RpcEstimatesmartfees(feeTargets)
+	if err != nil {
+		log.Warning("estimatesmartfee failed: %s", err.Error())
+	}
+	for i, nodeFee := range nodeFees {
+		if nodeFee != nil {
+			//BTC per kB to satoshi per byte.
+			rate := nodeFee.FeeRate * 1e8 / 1000
+			fees.Estimates[i].NodeFeeRate = &rate
+		}
+	}
+
+	return marshalV1(fees)
+}
+
+func newBlockFees(block *ModelBlock, txFees []*TxFee) *BlockFeesV1 {
+	sort.Sort(byFeeRate(txFees))
+	return &BlockFeesV1{
+		Height:        block.Height,
+		Hash:          hashHex(block.Hash),
+		TxCount:       len(txFees),
+		MinFeeRate:    roundRate(txFees[0].Rate()),
+		EntryFeeRate:  roundRate(rateAtShare(txFees, blockEntryShare)),
+		MedianFeeRate: roundRate(rateAtShare(txFees, 0.5)),
+		MaxFeeRate:    roundRate(txFees[len(txFees)-1].Rate()),
+	}
+}
+
+// rateAtShare returns the fee rate of the tx reached after share of the
+// bytes of txFees, which are sorted by ascending fee rate.
+func rateAtShare(txFees []*TxFee, share float64) float64 {
+	var total, sum int64
+	for _, f := range txFees {
+		total += f.Size
+	}
+	for _, f := range txFees {
+		sum += f.Size
+		if float64(sum) >= share*float64(total) {
+			return f.Rate()
+		}
+	}
+	return txFees[len(txFees)-1].Rate()
+}
+
+func newMempoolFees(mempool []*TxFee) *MempoolFeesV1 {
+	m := &MempoolFeesV1{TxCount: len(mempool)}
+	for _, rate := range feeBuckets {
+		m.Histogram = append(m.Histogram, &FeeBucketV1{MinFeeRate: rate})
+	}
+	for _, f := range mempool {
+		m.Size += f.Size
+		m.TotalFee += f.Fee
+		//Below the first bucket ends up in the first one.
+		bucket := sort.SearchFloat64s(feeBuckets, f.Rate()+1e-9) - 1
+		if bucket < 0 {
+			bucket = 0
+		}
+		m.Histogram[bucket].TxCount++
+		m.Histogram[bucket].Size += f.Size
+	}
+	return m
+}
+
+// estimateFees cuts the mempool, best paying first, into blocks. A target
+// of n blocks needs the fee rate found at the end of the n-th block. When
+// the mempool is smaller, any rate recent blocks accepted will do, taken as
+// the median of their entry rates.
+func estimateFees(blocks []*BlockFeesV1, mempool []*TxFee) []*FeeEstimateV1 {
+	floor := minRelayFeeRate
+	if len(blocks) > 0 {
+		var entries []float64
+		for _, b := range blocks {
+			entries = append(entries, b.EntryFeeRate)
+		}
+		sort.Float64s(entries)
+		floor = math.Max(floor, entries[len(entries)/2])
+	}
+
+	sorted := make([]*TxFee, len(mempool))
+	copy(sorted, mempool)
+	sort.Sort(sort.Reverse(byFeeRate(sorted)))
+
+	var estimates []*FeeEstimateV1
+	for i, target := range feeTargets {
+		e := &FeeEstimateV1{Target: target, FeeRate: floor, Source: "blocks"}
+		var sum int64
+		for _, f := range sorted {
+			sum += f.Size
+			if sum >= target*maxBlockSize {
+				e.FeeRate = math.Max(f.Rate(), minRelayFeeRate)
+				e.Source = "mempool"
+				break
+			}
+		}
+		//A longer target never needs a higher rate.
+		if i > 0 && e.FeeRate > estimates[i-1].FeeRate {
+			e.FeeRate = estimates[i-1].FeeRate
+		}
+		e.FeeRate = roundRate(e.FeeRate)
+		estimates = append(estimates, e)
+	}
+	return estimates
+}
+
+// roundRate rounds up to 0.01 satoshi per byte.
+func roundRate(rate float64) float64 {
+	return math.Ceil(rate*100) / 100
+}
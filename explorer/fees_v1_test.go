@@ -0,0 +1,70 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"testing"
+	"time"
+)
+
+func TestEstimateFeesMempool01(t *testing.T) {
+	//Two full blocks at 50 and 20 sat/B, then a small tail at 5 sat/B.
+	mempool := []*TxFee{
+		{TxId: 1, Size: 500000, Fee: 500000 * 5},
+		{TxId: 2, Size: maxBlockSize, Fee: maxBlockSize * 20},
+		{TxId: 3, Size: maxBlockSize, Fee: maxBlockSize * 50},
+	}
+	blocks := []*BlockFeesV1{{EntryFeeRate: 8}, {EntryFeeRate: 10}, {EntryFeeRate: 12}}
+	estimates := estimateFees(blocks, mempool)
+
+	expected := []struct {
+		rate   float64
+		source string
+	}{{50, "mempool"}, {20, "mempool"}, {10, "blocks"}, {10, "blocks"}, {10, "blocks"}, {10, "blocks"}}
+	for i, e := range estimates {
+		if e.Target != feeTargets[i] || e.FeeRate != expected[i].rate || e.Source != expected[i].source {
+			t.Errorf("Target %d: got %v %s, expected %v %s.", e.Target, e.FeeRate, e.Source, expected[i].rate, expected[i].source)
+		}
+	}
+}
+
+func TestEstimateFeesEmpty01(t *testing.T) {
+	for _, e := range estimateFees(nil, nil) {
+		if e.FeeRate != minRelayFeeRate {
+			t.Errorf("Target %d: got %v, expected the minimum relay fee rate.", e.Target, e.FeeRate)
+		}
+	}
+}
+
+func TestMempoolHistogram01(t *testing.T) {
+	m := newMempoolFees([]*TxFee{
+		{Size: 200, Fee: 100},
+		{Size: 250, Fee: 500},
+		{Size: 100, Fee: 250},
+	})
+	if m.TxCount != 3 || m.Size != 550 || m.TotalFee != 850 {
+		t.Errorf("Got %d txs, %d bytes, %d fee.", m.TxCount, m.Size, m.TotalFee)
+	}
+	//0.5 sat/B falls into the first bucket, 2 and 2.5 into the second.
+	if m.Histogram[0].TxCount != 1 || m.Histogram[1].TxCount != 2 || m.Histogram[1].Size != 350 {
+		t.Errorf("Unexpected buckets %+v %+v.", m.Histogram[0], m.Histogram[1])
+	}
+}
+
+func TestBlockFees01(t *testing.T) {
+	b := newBlockFees(&ModelBlock{Height: 7}, []*TxFee{
+		{Size: 100, Fee: 3000},
+		{Size: 900, Fee: 900},
+		{Size: 100, Fee: 200},
+	})
+	if b.MinFeeRate != 1 || b.MaxFeeRate != 30 || b.EntryFeeRate != 1 || b.MedianFeeRate != 1 {
+		t.Errorf("Unexpected block fees %+v.", b)
+	}
+}
+
+func TestFeesCache01(t *testing.T) {
+	defer func() { feesCache.body = "" }()
+	feesCache.version, feesCache.time, feesCache.body = IndexVersion(), time.Now(), `{"cached":true}`
+	if body, err := GetFeesV1(); err != nil || body != `{"cached":true}` {
+		t.Errorf("Got %s %v.", body, err)
+	}
+}
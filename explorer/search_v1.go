@@ -0,0 +1,152 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	//Shorter prefixes match too much to be useful.
+	minSearchPrefix  = 6
+	maxSearchResults = 10
+)
+
+// What a search query looks like, before looking anything up.
+const (
+	queryHeight = iota
+	queryHash
+	queryPrefix
+	queryAddress
+)
+
+type SearchV1 struct {
+	Query   string            `json:"query"`
+	Results []*SearchResultV1 `json:"results"`
+}
+
+// SearchResultV1 names the resource found and the URL serving it.
+type SearchResultV1 struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+	Url  string `json:"url"`
+}
+
+func ApiSearchV1(req *http.Request, rid requestId) (int, string) {
+	return rid.respond(GetSearchV1(req.URL.Query().Get("q")))
+}
+
+// classifyQuery tells heights, full hashes and hash prefixes apart, any
+// other query may be an address. Numbers with a leading zero are hash
+// prefixes, as most block hashes start with zeros.
+func classifyQuery(q string) []int {
+	isHex := len(q) > 0 && strings.Trim(q, "0123456789abcdefABCDEF") == ""
+	isNumber := len(q) > 0 && strings.Trim(q, "0123456789") == ""
+	var kinds []int
+	if isNumber && len(q) <= 10 && (q == "0" || q[0] != '0') {
+		kinds = append(kinds, queryHeight)
+	}
+	switch {
+	case isHex && len(q) == 2*BytesPerBlockHash:
+		return append(kinds, queryHash)
+	case isHex && len(q) >= minSearchPrefix && len(q) < 2*BytesPerBlockHash:
+		//A base58 address may consist of hex digits only.
+		return append(kinds, queryAddress, queryPrefix)
+	}
+	return append(kinds, queryAddress)
+}
+
+func GetSearchV1(q string) (string, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return "", errBadRequest("invalid_request", "q is required.")
+	}
+	search := &SearchV1{Query: q, Results: []*SearchResultV1{}}
+	for _, kind := range classifyQuery(q) {
+		results, err := searchKind(kind, q)
+		if err != nil {
+			return "", errDb(err, "Block or tx")
+		}
+		if len(results) > 0 {
+			search.Results = results
+			break
+		}
+	}
+	if len(search.Results) == 0 {
+		return "", errNotFound("Nothing matches the query.")
+	}
+	return marshalV1(search)
+}
+
+func blockResult(block *ModelBlock) *SearchResultV1 {
+	hash := hashHex(block.Hash)
+	return &SearchResultV1{Type: "block", Id: hash, Url: "/api/v1/block/" + hash}
+}
+
+func txResult(tx *ModelTx) *SearchResultV1 {
+	hash := hashHex(tx.Hash)
+	return &SearchResultV1{Type: "tx", Id: hash, Url: "/api/v1/tx/" + hash}
+}
+
+// searchKind looks q up as kind, not finding anything is no error.
+func searchKind(kind int, q string) ([]*SearchResultV1, error) {
+	switch kind {
+	case queryHeight:
+		height, _ := strconv.ParseInt(q, 10, 64)
+		block, err := queries.BlockByHeight(height)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*SearchResultV1{blockResult(block)}, nil
+
+	case queryHash:
+		hash, err := NewHashFromStr(strings.ToLower(q))
+		if err != nil {
+			return nil, err
+		}
+		if block, err := queries.BlockByHash(hash); err == nil {
+			return []*SearchResultV1{blockResult(block)}, nil
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+		if tx, err := queries.TxByHash(hash); err == nil {
+			return []*SearchResultV1{txResult(tx)}, nil
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, nil
+
+	case queryPrefix:
+		prefix := strings.ToLower(q)
+		var results []*SearchResultV1
+		blocks, err := BlocksByHashPrefix(dbmap, prefix, maxSearchResults)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range blocks {
+			results = append(results, blockResult(block))
+		}
+		txs, err := TxsByHashPrefix(dbmap, prefix, maxSearchResults-len(results))
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txs {
+			results = append(results, txResult(tx))
+		}
+		return results, nil
+
+	case queryAddress:
+		//Also takes hex public keys, shown as their P2PKH address.
+		address, err := ParseAddress(q)
+		if err != nil {
+			return nil, nil
+		}
+		return []*SearchResultV1{{Type: "address", Id: address, Url: "/api/v1/balance/" + address}}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,31 @@
+package explorer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyQuery01(t *testing.T) {
+	cases := []struct {
+		q     string
+		kinds []int
+	}{
+		{"0", []int{queryHeight, queryAddress}},
+		{"170", []int{queryHeight, queryAddress}},
+		{"227931", []int{queryHeight, queryAddress, queryPrefix}},
+		{"000000", []int{queryAddress, queryPrefix}},
+		{"00000000001", []int{queryAddress, queryPrefix}},
+		{"99999999999", []int{queryAddress, queryPrefix}},
+		{"f4184fc5", []int{queryAddress, queryPrefix}},
+		{"f4184", []int{queryAddress}},
+		{"f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16", []int{queryHash}},
+		{"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", []int{queryHash}},
+		{"12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S", []int{queryAddress}},
+		{"", []int{queryAddress}},
+	}
+	for _, c := range cases {
+		if kinds := classifyQuery(c.q); !reflect.DeepEqual(kinds, c.kinds) {
+			t.Errorf("%q: got %v, expected %v.", c.q, kinds, c.kinds)
+		}
+	}
+}
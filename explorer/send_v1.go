@@ -0,0 +1,191 @@
+package explorer
+
+import (
+	"Assange/bitcoinrpc"
+	. "Assange/blockdata"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/conformal/btcwire"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Standard txs are at most 100kB, twice that in hex.
+const maxSendBodyBytes = 200000
+
+type SendTxV1 struct {
+	Hash string `json:"hash"`
+}
+
+// rejectReason maps a fragment of bitcoind's reject message to an error
+// code and message for API clients.
+type rejectReason struct {
+	fragment string
+	status   int
+	code     string
+	message  string
+}
+
+var rejectReasons = []rejectReason{
+	{"missing-inputs", http.StatusBadRequest, "missing_inputs", "Inputs are unknown or already spent."},
+	{"missingorspent", http.StatusBadRequest, "missing_inputs", "Inputs are unknown or already spent."},
+	{"Missing inputs", http.StatusBadRequest, "missing_inputs", "Inputs are unknown or already spent."},
+	{"txn-mempool-conflict", http.StatusConflict, "double_spend", "An input is already spent by a tx in the mempool."},
+	{"insufficient fee", http.StatusBadRequest, "fee_too_low", "Fee is too low to replace the conflicting tx."},
+	{"min relay fee not met", http.StatusBadRequest, "fee_too_low", "Fee is below the minimum relay fee."},
+	{"mempool min fee not met", http.StatusBadRequest, "fee_too_low", "Fee is below the current mempool minimum."},
+	{"absurdly-high-fee", http.StatusBadRequest, "fee_too_high", "Fee is absurdly high, refused to protect the sender."},
+	{"max-fee-exceeded", http.StatusBadRequest, "fee_too_high", "Fee is absurdly high, refused to protect the sender."},
+	{"dust", http.StatusBadRequest, "dust", "An output is too small to be relayed."},
+	{"non-final", http.StatusBadRequest, "non_final", "Tx is not final yet, check lock_time and sequences."},
+	{"non-BIP68-final", http.StatusBadRequest, "non_final", "Tx is not final yet, check lock_time and sequences."},
+	{"script-verify-flag-failed", http.StatusBadRequest, "invalid_signature", "Script or signature verification failed."},
+	{"too-long-mempool-chain", http.StatusBadRequest, "mempool_chain_too_long", "Too many unconfirmed ancestors."},
+	{"bad-txns", http.StatusBadRequest, "invalid_tx", "Tx is invalid."},
+	{"", http.StatusBadRequest, "non_standard", "Tx is not standard and is not relayed."},
+}
+
+var errSendEmpty = errors.New("Tx has no inputs or no outputs.")
+var errSendCoinbase = errors.New("Coinbase txs can not be relayed.")
+
+var errBodyTooLarge = errBadRequest("invalid_request", "Request body is unreadable or too large.")
+
+// txIndexer stores relayed txs like the live indexer stores mempool txs, so
+// they count for their addresses right away. Without a live indexer they
+// are only inserted, their outputs are attributed by the next -buildblock.
+var txIndexer struct {
+	sync.Mutex
+	store func(tx *ModelTx) error
+}
+
+// SetTxIndexer hands relayed txs to store from now on.
+func SetTxIndexer(store func(tx *ModelTx) error) {
+	txIndexer.Lock()
+	txIndexer.store = store
+	txIndexer.Unlock()
+}
+
+func ApiSendTxV1(req *http.Request, rid requestId) (int, string) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxSendBodyBytes+1))
+	if err != nil || len(body) > maxSendBodyBytes {
+		return rid.respond("", errBodyTooLarge)
+	}
+	return rid.respond(SendTxV1Raw(bodyHex(req, body)))
+}
+
+// bodyHex accepts {"hex": "..."} or the bare hex as body.
+func bodyHex(req *http.Request, body []byte) string {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		var params struct {
+			Hex string `json:"hex"`
+		}
+		if json.Unmarshal(body, &params) == nil {
+			return strings.TrimSpace(params.Hex)
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func SendTxV1Raw(rawHex string) (string, error) {
+	tx, err := decodeSendTx(rawHex)
+	if err != nil {
+		return "", errBadRequest("invalid_tx", err.Error())
+	}
+	txid, err := bitcoinrpc.RpcSendrawtransaction(rawHex)
+	if err != nil {
+		rpcErr, ok := err.(*bitcoinrpc.RpcError)
+		if !ok {
+			log.Error("Relay tx %s: %s", tx.Hash, err.Error())
+			return "", &ApiError{http.StatusServiceUnavailable, "node_unavailable", "The bitcoin node can not be reached."}
+		}
+		if !alreadyKnown(rpcErr) {
+			status, code, message := mapReject(rpcErr)
+			log.Info("Tx %s rejected: %s", tx.Hash, rpcErr.Error())
+			return "", &ApiError{status, code, message + " (" + rpcErr.Message + ")"}
+		}
+		txid = hashHex(tx.Hash)
+	}
+
+	if err := indexSentTx(tx); err != nil {
+		//Relayed anyway, it will be indexed from the mempool or its block.
+		log.Error("Insert sent tx %s: %s", tx.Hash, err.Error())
+	}
+	return marshalV1(&SendTxV1{Hash: txid})
+}
+
+func decodeSendTx(rawHex string) (*ModelTx, error) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, err
+	}
+	msg, size, err := DecodeMsgTx(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.TxIn) == 0 || len(msg.TxOut) == 0 {
+		return nil, errSendEmpty
+	}
+	prev := msg.TxIn[0].PreviousOutPoint
+	if len(msg.TxIn) == 1 && prev.Index == btcwire.MaxPrevOutIndex && prev.Hash == (btcwire.ShaHash{}) {
+		return nil, errSendCoinbase
+	}
+	tx := new(ModelTx)
+	if err := tx.NewFromMsg(msg); err != nil {
+		return nil, err
+	}
+	tx.Size = size.Vsize()
+	return tx, nil
+}
+
+// alreadyKnown is true when bitcoind has the tx in its mempool already,
+// which is what the sender wanted.
+func alreadyKnown(rpcErr *bitcoinrpc.RpcError) bool {
+	return rpcErr.Code == bitcoinrpc.RpcErrVerifyRejected &&
+		(strings.Contains(rpcErr.Message, "txn-already-in-mempool") || strings.Contains(rpcErr.Message, "txn-already-known"))
+}
+
+func mapReject(rpcErr *bitcoinrpc.RpcError) (int, string, string) {
+	switch rpcErr.Code {
+	case bitcoinrpc.RpcErrDeserialization:
+		return http.StatusBadRequest, "invalid_tx", "Tx can not be decoded."
+	case bitcoinrpc.RpcErrVerifyAlreadyInChain:
+		return http.StatusConflict, "already_in_chain", "Tx is already confirmed."
+	case bitcoinrpc.RpcErrVerify, bitcoinrpc.RpcErrVerifyRejected:
+		for _, reason := range rejectReasons {
+			if strings.Contains(rpcErr.Message, reason.fragment) {
+				return reason.status, reason.code, reason.message
+			}
+		}
+	}
+	return http.StatusBadGateway, "node_error", "The bitcoin node refused the tx."
+}
+
+func indexSentTx(tx *ModelTx) error {
+	txIndexer.Lock()
+	store := txIndexer.store
+	txIndexer.Unlock()
+	if store != nil {
+		return store(tx)
+	}
+	return insertSentTx(tx)
+}
+
+func insertSentTx(tx *ModelTx) error {
+	if _, err := queries.TxByHash(tx.Hash); err != sql.ErrNoRows {
+		return err
+	}
+	trans, err := dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.InsertUnconfirmedIntoDb(trans); err != nil {
+		trans.Rollback()
+		return err
+	}
+	return trans.Commit()
+}
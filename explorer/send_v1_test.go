@@ -0,0 +1,82 @@
+package explorer
+
+import (
+	"Assange/bitcoinrpc"
+	. "Assange/blockdata"
+	. "Assange/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestMapReject01(t *testing.T) {
+	cases := []struct {
+		code   int
+		msg    string
+		status int
+		apiErr string
+	}{
+		{bitcoinrpc.RpcErrVerify, "bad-txns-inputs-missingorspent", http.StatusBadRequest, "missing_inputs"},
+		{bitcoinrpc.RpcErrVerify, "Missing inputs", http.StatusBadRequest, "missing_inputs"},
+		{bitcoinrpc.RpcErrVerifyRejected, "txn-mempool-conflict", http.StatusConflict, "double_spend"},
+		{bitcoinrpc.RpcErrVerifyRejected, "min relay fee not met, 100 < 141", http.StatusBadRequest, "fee_too_low"},
+		{bitcoinrpc.RpcErrVerifyRejected, "dust", http.StatusBadRequest, "dust"},
+		{bitcoinrpc.RpcErrVerifyRejected, "scriptpubkey", http.StatusBadRequest, "non_standard"},
+		{bitcoinrpc.RpcErrVerifyAlreadyInChain, "Transaction already in block chain", http.StatusConflict, "already_in_chain"},
+		{bitcoinrpc.RpcErrMiscError, "something else", http.StatusBadGateway, "node_error"},
+	}
+	for _, c := range cases {
+		status, apiErr, _ := mapReject(&bitcoinrpc.RpcError{Code: c.code, Message: c.msg})
+		if status != c.status || apiErr != c.apiErr {
+			t.Errorf("%d %q: got %d %s, expected %d %s.", c.code, c.msg, status, apiErr, c.status, c.apiErr)
+		}
+	}
+}
+
+func TestAlreadyKnown01(t *testing.T) {
+	if !alreadyKnown(&bitcoinrpc.RpcError{Code: bitcoinrpc.RpcErrVerifyRejected, Message: "txn-already-in-mempool"}) {
+		t.Error("txn-already-in-mempool should count as relayed.")
+	}
+	if alreadyKnown(&bitcoinrpc.RpcError{Code: bitcoinrpc.RpcErrVerifyRejected, Message: "txn-mempool-conflict"}) {
+		t.Error("txn-mempool-conflict is a rejection.")
+	}
+}
+
+func TestDecodeSendTx01(t *testing.T) {
+	for _, raw := range []string{"", "zz", "0100"} {
+		if _, err := decodeSendTx(raw); err == nil {
+			t.Errorf("Expected an error decoding %q.", raw)
+		}
+	}
+}
+
+func TestSendTxIndexed01(t *testing.T) {
+	//One input and one P2WPKH output, with witness.
+	const rawHex = "01000000000101" + "1111111111111111111111111111111111111111111111111111111111111111" + "02000000" + "00" + "ffffffff" +
+		"01" + "a086010000000000" + "16" + "00142222222222222222222222222222222222222222" + "02" + "03aabbcc" + "02dddd" + "00000000"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":"00","error":null,"id":1}`)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+	if err := bitcoinrpc.InitRpcClient(Configuration{Rpc_host: u.Hostname(), Rpc_port: port}); err != nil {
+		t.Fatal(err)
+	}
+	var stored []*ModelTx
+	SetTxIndexer(func(tx *ModelTx) error {
+		stored = append(stored, tx)
+		return nil
+	})
+	defer SetTxIndexer(nil)
+	if _, err := SendTxV1Raw(rawHex); err != nil {
+		t.Fatal(err)
+	}
+	//The indexer extracts the outputs, which attributes them to addresses.
+	if len(stored) != 1 || stored[0].Msg == nil || len(stored[0].Msg.TxOut) != 1 || stored[0].Size != 85 {
+		t.Fatalf("Indexer got %+v.", stored)
+	}
+}
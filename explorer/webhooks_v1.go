@@ -0,0 +1,290 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-martini/martini"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	maxWebhookWatches       = 1000
+	maxWebhookConfirmations = 100
+	maxWebhookUrlLen        = 2048
+	maxWebhookBodyBytes     = 200000
+)
+
+// WebhookRequestV1 registers a webhook for txs touching any of the
+// addresses or having one of the txids.
+type WebhookRequestV1 struct {
+	Url           string   `json:"url"`
+	Addresses     []string `json:"addresses"`
+	Txids         []string `json:"txids"`
+	Confirmations int64    `json:"confirmations"`
+}
+
+type WebhookV1 struct {
+	Id            string   `json:"id"`
+	Url           string   `json:"url"`
+	Addresses     []string `json:"addresses"`
+	Txids         []string `json:"txids"`
+	Confirmations int64    `json:"confirmations"`
+	Active        bool     `json:"active"`
+	Created       int64    `json:"created"`
+	//Only answered when the webhook is registered
+	Secret string `json:"secret,omitempty"`
+}
+
+type DeliveriesV1 struct {
+	Deliveries []*DeliveryV1 `json:"deliveries"`
+	//Pass as before to get the next page, -1 on the last page
+	Next int64 `json:"next"`
+}
+
+type DeliveryV1 struct {
+	Id       int64  `json:"id"`
+	Txid     string `json:"txid"`
+	Status   string `json:"status"`
+	Attempts int64  `json:"attempts"`
+	//Null unless the delivery is pending
+	NextAttempt *int64          `json:"next_attempt"`
+	LastError   string          `json:"last_error"`
+	Created     int64           `json:"created"`
+	Payload     json.RawMessage `json:"payload"`
+	Log         []*AttemptV1    `json:"log"`
+}
+
+// AttemptV1 is one POST of a delivery, status_code is 0 when the receiver
+// did not answer.
+type AttemptV1 struct {
+	Time       int64  `json:"time"`
+	StatusCode int64  `json:"status_code"`
+	Error      string `json:"error"`
+	Duration   int64  `json:"duration_ms"`
+}
+
+var errDeliveriesParams = errBadRequest("invalid_request", "limit must be 1 to 100, before a delivery id.")
+
+func ApiCreateWebhookV1(req *http.Request, rid requestId) (int, string) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxWebhookBodyBytes+1))
+	if err != nil || len(body) > maxWebhookBodyBytes {
+		return rid.respond("", errBodyTooLarge)
+	}
+	return rid.respond(CreateWebhookV1(body))
+}
+
+func ApiWebhookV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(GetWebhookV1(params["id"]))
+}
+
+func ApiDeleteWebhookV1(params martini.Params, rid requestId) (int, string) {
+	return rid.respond(DeleteWebhookV1(params["id"]))
+}
+
+func ApiWebhookDeliveriesV1(params martini.Params, req *http.Request, rid requestId) (int, string) {
+	query := req.URL.Query()
+	return rid.respond(GetWebhookDeliveriesV1(params["id"], query.Get("limit"), query.Get("before")))
+}
+
+// parseWebhookRequest checks a registration and returns the webhook and
+// the addresses and txids it watches, without duplicates.
+func parseWebhookRequest(body []byte) (*ModelWebhook, []*ModelWebhookWatch, error) {
+	r := new(WebhookRequestV1)
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, nil, errBadRequest("invalid_request", "Body is no webhook JSON object.")
+	}
+	u, err := url.Parse(r.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(r.Url) > maxWebhookUrlLen {
+		return nil, nil, errBadRequest("invalid_url", "url must be an absolute http or https URL.")
+	}
+	if r.Confirmations < 0 || r.Confirmations > maxWebhookConfirmations {
+		return nil, nil, errBadRequest("invalid_request", "confirmations must be 0 to 100.")
+	}
+	seen := make(map[string]bool)
+	var watches []*ModelWebhookWatch
+	for _, raw := range r.Addresses {
+		addr, err := ParseAddress(raw)
+		if err != nil {
+			return nil, nil, errBadRequest("invalid_address", "Invalid address "+raw+".")
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			watches = append(watches, &ModelWebhookWatch{Kind: WebhookWatchAddress, Value: addr})
+		}
+	}
+	for _, txid := range r.Txids {
+		hash, err := NewHashFromStr(strings.ToLower(txid))
+		if err != nil {
+			return nil, nil, errBadRequest("invalid_hash", "Invalid txid "+txid+".")
+		}
+		if value := hash.String(); !seen[value] {
+			seen[value] = true
+			watches = append(watches, &ModelWebhookWatch{Kind: WebhookWatchTxid, Value: value})
+		}
+	}
+	if len(watches) == 0 || len(watches) > maxWebhookWatches {
+		return nil, nil, errBadRequest("invalid_request", "Watch 1 to 1000 addresses and txids.")
+	}
+	hook := &ModelWebhook{
+		PublicId:      randomHex(16),
+		Url:           r.Url,
+		Secret:        randomHex(32),
+		Confirmations: r.Confirmations,
+		Active:        true,
+		Created:       time.Now(),
+	}
+	return hook, watches, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newWebhookV1(hook *ModelWebhook, watches []*ModelWebhookWatch) *WebhookV1 {
+	w := &WebhookV1{
+		Id:            hook.PublicId,
+		Url:           hook.Url,
+		Addresses:     []string{},
+		Txids:         []string{},
+		Confirmations: hook.Confirmations,
+		Active:        hook.Active,
+		Created:       hook.Created.Unix(),
+	}
+	for _, watch := range watches {
+		if watch.Kind == WebhookWatchTxid {
+			w.Txids = append(w.Txids, watch.Value)
+		} else {
+			w.Addresses = append(w.Addresses, watch.Value)
+		}
+	}
+	return w
+}
+
+// CreateWebhookV1 registers a webhook. The secret signing its deliveries is
+// only answered here.
+func CreateWebhookV1(body []byte) (string, error) {
+	hook, watches, err := parseWebhookRequest(body)
+	if err != nil {
+		return "", err
+	}
+	trans, err := dbmap.Begin()
+	if err != nil {
+		return "", errDb(err, "Webhook")
+	}
+	if err := CreateWebhook(trans, hook, watches); err != nil {
+		trans.Rollback()
+		return "", errDb(err, "Webhook")
+	}
+	if err := trans.Commit(); err != nil {
+		return "", errDb(err, "Webhook")
+	}
+	w := newWebhookV1(hook, watches)
+	w.Secret = hook.Secret
+	return marshalV1(w)
+}
+
+func webhookByPublicId(publicId string) (*ModelWebhook, error) {
+	hook, err := WebhookByPublicId(dbmap, publicId)
+	if err != nil {
+		return nil, errDb(err, "Webhook")
+	}
+	return hook, nil
+}
+
+func GetWebhookV1(publicId string) (string, error) {
+	hook, err := webhookByPublicId(publicId)
+	if err != nil {
+		return "", err
+	}
+	watches, err := WebhookWatches(dbmap, hook.Id)
+	if err != nil {
+		return "", errDb(err, "Webhook")
+	}
+	return marshalV1(newWebhookV1(hook, watches))
+}
+
+// DeleteWebhookV1 stops a webhook, deleting it again changes nothing. The
+// webhook and its delivery log stay readable.
+func DeleteWebhookV1(publicId string) (string, error) {
+	hook, err := webhookByPublicId(publicId)
+	if err != nil {
+		return "", err
+	}
+	watches, err := WebhookWatches(dbmap, hook.Id)
+	if err != nil {
+		return "", errDb(err, "Webhook")
+	}
+	if !hook.Active {
+		return marshalV1(newWebhookV1(hook, watches))
+	}
+	trans, err := dbmap.Begin()
+	if err != nil {
+		return "", errDb(err, "Webhook")
+	}
+	if err := DeactivateWebhook(trans, hook.Id); err != nil {
+		trans.Rollback()
+		return "", errDb(err, "Webhook")
+	}
+	if err := trans.Commit(); err != nil {
+		return "", errDb(err, "Webhook")
+	}
+	hook.Active = false
+	return marshalV1(newWebhookV1(hook, watches))
+}
+
+func GetWebhookDeliveriesV1(publicId string, limitStr string, beforeStr string) (string, error) {
+	limit, before, err := parsePageParams(limitStr, beforeStr, errDeliveriesParams)
+	if err != nil {
+		return "", err
+	}
+	hook, err := webhookByPublicId(publicId)
+	if err != nil {
+		return "", err
+	}
+	mDeliveries, err := WebhookDeliveries(dbmap, hook.Id, before, limit)
+	if err != nil {
+		return "", errDb(err, "Delivery")
+	}
+	deliveries := &DeliveriesV1{Deliveries: []*DeliveryV1{}, Next: -1}
+	for _, mDelivery := range mDeliveries {
+		attempts, err := WebhookAttempts(dbmap, mDelivery.Id)
+		if err != nil {
+			return "", errDb(err, "Delivery")
+		}
+		deliveries.Deliveries = append(deliveries.Deliveries, newDeliveryV1(mDelivery, attempts))
+	}
+	if n := len(mDeliveries); int64(n) == limit {
+		deliveries.Next = mDeliveries[n-1].Id
+	}
+	return marshalV1(deliveries)
+}
+
+func newDeliveryV1(d *ModelWebhookDelivery, attempts []*ModelWebhookAttempt) *DeliveryV1 {
+	delivery := &DeliveryV1{
+		Id:        d.Id,
+		Txid:      hashHex(d.TxHash),
+		Status:    d.Status,
+		Attempts:  d.Attempts,
+		LastError: d.LastError,
+		Created:   d.Created.Unix(),
+		Payload:   json.RawMessage(d.Payload),
+		Log:       []*AttemptV1{},
+	}
+	if d.Status == WebhookPending {
+		next := d.NextAttempt.Unix()
+		delivery.NextAttempt = &next
+	}
+	for _, a := range attempts {
+		delivery.Log = append(delivery.Log, &AttemptV1{Time: a.Time.Unix(), StatusCode: a.StatusCode, Error: a.Error, Duration: a.Duration})
+	}
+	return delivery
+}
@@ -0,0 +1,64 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"testing"
+	"time"
+)
+
+func TestParseWebhookRequest01(t *testing.T) {
+	hook, watches, err := parseWebhookRequest([]byte(`{"url": "https://example.com/hook", "confirmations": 3,
+		"addresses": ["12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S", "12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S"],
+		"txids": ["F4184FC596403B9D638783CF57ADFE4C75C605F6356FBC91338530E9831E9E16"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.PublicId) != 32 || len(hook.Secret) != 64 || hook.Confirmations != 3 || !hook.Active {
+		t.Errorf("Unexpected webhook: %+v.", hook)
+	}
+	if len(watches) != 2 || *watches[0] != (ModelWebhookWatch{Kind: WebhookWatchAddress, Value: "12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S"}) ||
+		*watches[1] != (ModelWebhookWatch{Kind: WebhookWatchTxid, Value: wsTestTxid}) {
+		t.Errorf("Unexpected watches: %+v.", watches)
+	}
+	w := newWebhookV1(hook, watches)
+	if len(w.Addresses) != 1 || len(w.Txids) != 1 || w.Txids[0] != wsTestTxid || w.Secret != "" {
+		t.Errorf("Unexpected webhook: %+v.", w)
+	}
+	//Addresses of a txid's length are told apart by their kind.
+	long := &ModelWebhookWatch{Kind: WebhookWatchAddress, Value: "bc1" + wsTestTxid[3:]}
+	if w := newWebhookV1(hook, []*ModelWebhookWatch{long}); len(w.Addresses) != 1 || len(w.Txids) != 0 {
+		t.Errorf("Unexpected webhook: %+v.", w)
+	}
+}
+
+func TestParseWebhookRequest02(t *testing.T) {
+	cases := map[string]string{
+		`[]`: "invalid_request",
+		`{"url": "ftp://example.com", "txids": ["` + wsTestTxid + `"]}`:                        "invalid_url",
+		`{"url": "/hook", "txids": ["` + wsTestTxid + `"]}`:                                    "invalid_url",
+		`{"url": "http://example.com"}`:                                                        "invalid_request",
+		`{"url": "http://example.com", "addresses": ["1A"]}`:                                   "invalid_address",
+		`{"url": "http://example.com", "txids": ["00"]}`:                                       "invalid_hash",
+		`{"url": "http://example.com", "confirmations": 101, "txids": ["` + wsTestTxid + `"]}`: "invalid_request",
+	}
+	for body, code := range cases {
+		_, _, err := parseWebhookRequest([]byte(body))
+		if apiErr, ok := err.(*ApiError); !ok || apiErr.Code != code {
+			t.Errorf("%s: got %v, expected %s.", body, err, code)
+		}
+	}
+}
+
+func TestDeliveryV101(t *testing.T) {
+	hash, _ := NewHashFromStr(wsTestTxid)
+	now := time.Unix(1400000000, 0)
+	d := &ModelWebhookDelivery{Id: 7, TxHash: hash, Payload: `{"event":"tx"}`, Status: WebhookPending, Attempts: 1, NextAttempt: now, Created: now}
+	delivery := newDeliveryV1(d, []*ModelWebhookAttempt{{Time: now, StatusCode: 500, Error: "Receiver answered 500."}})
+	if delivery.Txid != wsTestTxid || delivery.NextAttempt == nil || *delivery.NextAttempt != now.Unix() || len(delivery.Log) != 1 {
+		t.Errorf("Unexpected delivery: %+v.", delivery)
+	}
+	d.Status = WebhookDead
+	if delivery = newDeliveryV1(d, nil); delivery.NextAttempt != nil || delivery.Log == nil {
+		t.Errorf("Unexpected dead delivery: %+v.", delivery)
+	}
+}
@@ -0,0 +1,235 @@
+package explorer
+
+import (
+	. "Assange/blockdata"
+	"Assange/notify"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Events buffered per connection before it counts as too slow.
+	wsEventBuffer = 1000
+	//Addresses and txids one connection may watch.
+	maxWsWatches    = 1000
+	maxWsMessageLen = 65536
+	wsWriteWait     = 10 * time.Second
+	wsPongWait      = 60 * time.Second
+	wsPingPeriod    = wsPongWait * 9 / 10
+)
+
+// The API is public and read only, so any origin may connect.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+var errWsAction = errors.New("action must be subscribe or unsubscribe.")
+var errWsTooMany = errors.New("Too many addresses and txids watched.")
+
+// WsRequestV1 changes what a connection receives. Subscribing adds to the
+// current selection, unsubscribing removes from it.
+type WsRequestV1 struct {
+	Action    string   `json:"action"`
+	Blocks    bool     `json:"blocks"`
+	Txs       bool     `json:"txs"`
+	Addresses []string `json:"addresses"`
+	Txids     []string `json:"txids"`
+}
+
+// WsReplyV1 answers a request with the resulting selection, or an error.
+type WsReplyV1 struct {
+	Type      string   `json:"type"`
+	Message   string   `json:"message,omitempty"`
+	Blocks    bool     `json:"blocks"`
+	Txs       bool     `json:"txs"`
+	Addresses []string `json:"addresses"`
+	Txids     []string `json:"txids"`
+}
+
+// wsFilter is the selection of one connection.
+type wsFilter struct {
+	blocks    bool
+	txs       bool
+	addresses map[string]bool
+	txids     map[string]bool
+}
+
+func newWsFilter() *wsFilter {
+	return &wsFilter{addresses: make(map[string]bool), txids: make(map[string]bool)}
+}
+
+// apply checks all of req before changing anything.
+func (f *wsFilter) apply(req *WsRequestV1) error {
+	if req.Action != "subscribe" && req.Action != "unsubscribe" {
+		return errWsAction
+	}
+	addresses := make([]string, len(req.Addresses))
+	for i, addr := range req.Addresses {
+		parsed, err := ParseAddress(addr)
+		if err != nil {
+			return errors.New("Invalid address " + addr + ".")
+		}
+		addresses[i] = parsed
+	}
+	txids := make([]string, len(req.Txids))
+	for i, txid := range req.Txids {
+		hash, err := NewHashFromStr(strings.ToLower(txid))
+		if err != nil {
+			return errors.New("Invalid txid " + txid + ".")
+		}
+		txids[i] = hash.String()
+	}
+	subscribe := req.Action == "subscribe"
+	if subscribe && len(f.addresses)+len(f.txids)+len(addresses)+len(txids) > maxWsWatches {
+		return errWsTooMany
+	}
+	if req.Blocks {
+		f.blocks = subscribe
+	}
+	if req.Txs {
+		f.txs = subscribe
+	}
+	for _, addr := range addresses {
+		if subscribe {
+			f.addresses[addr] = true
+		} else {
+			delete(f.addresses, addr)
+		}
+	}
+	for _, txid := range txids {
+		if subscribe {
+			f.txids[txid] = true
+		} else {
+			delete(f.txids, txid)
+		}
+	}
+	return nil
+}
+
+func (f *wsFilter) match(e *notify.Event) bool {
+	switch e.Type {
+	case notify.BlockConnected:
+		return f.blocks
+	case notify.BlockDisconnected:
+		if f.blocks || f.txs {
+			return true
+		}
+		for _, txid := range e.Txs {
+			if f.txids[txid] {
+				return true
+			}
+		}
+		return false
+	}
+	if f.txs || f.txids[e.Hash] {
+		return true
+	}
+	for _, addr := range e.Addresses {
+		if f.addresses[addr] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *wsFilter) reply() *WsReplyV1 {
+	r := &WsReplyV1{Type: "subscribed", Blocks: f.blocks, Txs: f.txs, Addresses: []string{}, Txids: []string{}}
+	for addr := range f.addresses {
+		r.Addresses = append(r.Addresses, addr)
+	}
+	for txid := range f.txids {
+		r.Txids = append(r.Txids, txid)
+	}
+	return r
+}
+
+// ApiWsV1 pushes the events of the indexer selected by the client.
+func ApiWsV1(w http.ResponseWriter, req *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		//The upgrader answered the request already.
+		log.Info("WebSocket upgrade failed: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+	var mutex sync.Mutex
+	filter := newWsFilter()
+	sub := notify.Subscribe(wsEventBuffer, func(e *notify.Event) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return filter.match(e)
+	})
+	defer sub.Close()
+	replies := make(chan *WsReplyV1, 8)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go wsRead(conn, filter, &mutex, replies, done, stop)
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too slow."), time.Now().Add(wsWriteWait))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(e)
+		case r := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(r)
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wsRead applies the client's requests until the connection fails or the
+// writer stops.
+func wsRead(conn *websocket.Conn, filter *wsFilter, mutex *sync.Mutex, replies chan<- *WsReplyV1, done chan<- struct{}, stop <-chan struct{}) {
+	defer close(done)
+	conn.SetReadLimit(maxWsMessageLen)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var reply *WsReplyV1
+		req := new(WsRequestV1)
+		if err := json.Unmarshal(message, req); err != nil {
+			reply = &WsReplyV1{Type: "error", Message: "Request is no JSON object."}
+		} else {
+			mutex.Lock()
+			if err := filter.apply(req); err != nil {
+				reply = &WsReplyV1{Type: "error", Message: err.Error()}
+			} else {
+				reply = filter.reply()
+			}
+			mutex.Unlock()
+		}
+		select {
+		case replies <- reply:
+		case <-stop:
+			return
+		}
+	}
+}
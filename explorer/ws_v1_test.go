@@ -0,0 +1,92 @@
+package explorer
+
+import (
+	"Assange/notify"
+	"testing"
+)
+
+const wsTestTxid = "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16"
+
+func TestWsFilterMatch01(t *testing.T) {
+	f := newWsFilter()
+	block := &notify.Event{Type: notify.BlockConnected, Hash: "00"}
+	tx := &notify.Event{Type: notify.TxAdded, Hash: wsTestTxid}
+	if f.match(block) || f.match(tx) {
+		t.Error("Empty filter matched.")
+	}
+	if err := f.apply(&WsRequestV1{Action: "subscribe", Blocks: true, Txids: []string{"F4184FC596403B9D638783CF57ADFE4C75C605F6356FBC91338530E9831E9E16"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.match(block) || !f.match(tx) {
+		t.Error("Subscribed events not matched.")
+	}
+	if f.match(&notify.Event{Type: notify.TxAdded, Hash: "01"}) {
+		t.Error("Other tx matched.")
+	}
+	f.addresses["12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S"] = true
+	if !f.match(&notify.Event{Type: notify.TxRemoved, Hash: "01", Addresses: []string{"1A", "12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S"}}) {
+		t.Error("Tx of a watched address not matched.")
+	}
+	if err := f.apply(&WsRequestV1{Action: "subscribe", Addresses: []string{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.match(&notify.Event{Type: notify.TxAdded, Hash: "02", Addresses: []string{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}}) {
+		t.Error("Tx of a watched bech32 address not matched.")
+	}
+	if err := f.apply(&WsRequestV1{Action: "unsubscribe", Blocks: true, Txids: []string{wsTestTxid}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.match(block) || f.match(tx) {
+		t.Error("Unsubscribed events matched.")
+	}
+}
+
+func TestWsFilterMatchDisconnected01(t *testing.T) {
+	f := newWsFilter()
+	e := &notify.Event{Type: notify.BlockDisconnected, Hash: "00", Height: 1, Txs: []string{wsTestTxid}}
+	if f.match(e) {
+		t.Error("Empty filter matched.")
+	}
+	for _, req := range []*WsRequestV1{
+		{Action: "subscribe", Blocks: true},
+		{Action: "subscribe", Txs: true},
+		{Action: "subscribe", Txids: []string{wsTestTxid}},
+	} {
+		f := newWsFilter()
+		if err := f.apply(req); err != nil {
+			t.Fatal(err)
+		}
+		if !f.match(e) {
+			t.Errorf("Disconnect not matched by %+v.", req)
+		}
+	}
+	if err := f.apply(&WsRequestV1{Action: "subscribe", Txids: []string{"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.match(e) {
+		t.Error("Disconnect returning other txs matched.")
+	}
+}
+
+func TestWsFilterApply01(t *testing.T) {
+	f := newWsFilter()
+	for _, req := range []*WsRequestV1{
+		{Action: "watch", Blocks: true},
+		{Action: "subscribe", Blocks: true, Txids: []string{wsTestTxid, "xyz"}},
+	} {
+		if err := f.apply(req); err == nil {
+			t.Errorf("Expected an error for %+v.", req)
+		}
+	}
+	//Invalid requests change nothing.
+	if r := f.reply(); r.Blocks || len(r.Txids) != 0 {
+		t.Errorf("Got %+v after invalid requests.", r)
+	}
+	many := make([]string, maxWsWatches+1)
+	for i := range many {
+		many[i] = wsTestTxid
+	}
+	if err := f.apply(&WsRequestV1{Action: "subscribe", Txids: many}); err != errWsTooMany {
+		t.Errorf("Expected errWsTooMany, got %v.", err)
+	}
+}
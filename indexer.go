@@ -0,0 +1,412 @@
+package main
+
+import (
+	. "Assange/bitcoinrpc"
+	. "Assange/blockdata"
+	"Assange/notify"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/conformal/btcwire"
+	"github.com/coopernurse/gorp"
+	"sync"
+)
+
+// Blocks announced after a catch up, older ones are skipped.
+const maxCatchUpEvents = 100
+
+// Deepest reorg rolled back. A fork further down means the index was built
+// from another chain than the node's and needs a rebuild.
+const maxReorgDepth = 100
+
+var ErrReorgTooDeep = errors.New("Fork with the node's chain is too deep to roll back")
+
+// indexer stores blocks and txs pushed by the P2P and ZMQ sources one at a
+// time. Inputs and outputs are extracted right away and spends resolved
+// after every insert. The handlers of the sources hold mutex, so do txs
+// relayed through the API.
+type indexer struct {
+	dbmap   *gorp.DbMap
+	queries *Queries
+	cache   *UtxoCache
+	mutex   sync.Mutex
+}
+
+func newIndexer(dbmap *gorp.DbMap, queries *Queries) *indexer {
+	return &indexer{dbmap: dbmap, queries: queries, cache: NewUtxoCache(Config.Utxo_cache_mb, queries)}
+}
+
+// tip returns the highest indexed block, nil for an empty DB.
+func (ix *indexer) tip() (*ModelBlock, error) {
+	height, err := GetMaxBlockHeightFromDB(ix.dbmap)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ix.queries.BlockByHeight(height)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return block, err
+}
+
+func (ix *indexer) hasBlock(hash Hash) (bool, error) {
+	_, err := ix.queries.BlockByHash(hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ix *indexer) hasTx(hash Hash) (bool, error) {
+	_, err := ix.queries.TxByHash(hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ix *indexer) resolve() {
+	extractTxout(ix.dbmap, ix.queries, ix.cache)
+	extractTxin(ix.dbmap, ix.cache)
+	IndexChanged()
+}
+
+func (ix *indexer) indexBlock(height int64, msg *btcwire.MsgBlock) error {
+	block := new(ModelBlock)
+	if err := block.NewFromMsg(height, msg); err != nil {
+		return err
+	}
+	return ix.storeBlock(block)
+}
+
+// storeBlock inserts a decoded block on top of the indexed tip.
+func (ix *indexer) storeBlock(block *ModelBlock) error {
+	//With several backends, stop rather than insert a block they disagree on
+	if err := RpcCheckBlockhash(block.Height, block.Hash.String()); err != nil {
+		return fmt.Errorf("Block %s at height %d: %s", block.Hash, block.Height, err.Error())
+	}
+	trans, err := ix.dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	if err := block.InsertIntoDb(trans, ix.queries); err != nil {
+		trans.Rollback()
+		return err
+	}
+	if err := insertBlockTxs(trans, block, block.Txs, true); err != nil {
+		trans.Rollback()
+		return err
+	}
+	if err := trans.Commit(); err != nil {
+		return err
+	}
+	ix.resolve()
+	ix.publishBlock(block)
+	ix.queueWebhooks()
+	return nil
+}
+
+func (ix *indexer) indexTx(msg *btcwire.MsgTx) error {
+	tx := new(ModelTx)
+	if err := tx.NewFromMsg(msg); err != nil {
+		return err
+	}
+	return ix.storeTx(tx)
+}
+
+// storeTx stores a decoded mempool tx unless it is known already.
+func (ix *indexer) storeTx(tx *ModelTx) error {
+	if known, err := ix.hasTx(tx.Hash); known || err != nil {
+		return err
+	}
+	trans, err := ix.dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.InsertUnconfirmedIntoDb(trans); err != nil {
+		trans.Rollback()
+		//Not fatal, the tx may have arrived in a block meanwhile.
+		log.Warning("Mempool tx %s not inserted: %s", tx.Hash, err.Error())
+		return nil
+	}
+	if err := trans.Commit(); err != nil {
+		return err
+	}
+	ix.resolve()
+	ix.publishTx(tx)
+	ix.queueWebhooks()
+	return nil
+}
+
+// storeSentTx stores a tx relayed through the API, called from its
+// goroutines.
+func (ix *indexer) storeSentTx(tx *ModelTx) error {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	return ix.storeTx(tx)
+}
+
+// removeTx drops an unconfirmed tx which left the mempool.
+func (ix *indexer) removeTx(hash Hash) error {
+	tx, err := ix.queries.TxByHash(hash)
+	if err == sql.ErrNoRows || (err == nil && tx.Confirmed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	//Buffered spends may refer to the inputs about to be deleted.
+	if err := ix.cache.Flush(ix.dbmap); err != nil {
+		return err
+	}
+	var addresses []string
+	if notify.HasSubscribers() {
+		if addresses, err = AddressesOfTx(ix.dbmap, tx.Id); err != nil {
+			return err
+		}
+	}
+	trans, err := ix.dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	var outs []*ModelTxout
+	if _, err := trans.Select(&outs, "select * from txout where TxId=?", tx.Id); err != nil {
+		trans.Rollback()
+		return err
+	}
+	if err := tx.DeleteUnconfirmedFromDb(trans); err != nil {
+		trans.Rollback()
+		return err
+	}
+	if err := trans.Commit(); err != nil {
+		return err
+	}
+	for _, out := range outs {
+		ix.cache.Forget(Outpoint{Hash: tx.Hash, Index: out.OutIndex})
+	}
+	IndexChanged()
+	notify.Publish(&notify.Event{Type: notify.TxRemoved, Hash: tx.Hash.String(), Addresses: addresses})
+	return nil
+}
+
+// forkHeight walks down from the indexed tip to the highest block bitcoind
+// still has on its chain. indexedHash looks up the indexed block at a height.
+func forkHeight(tipHeight int64, indexedHash func(height int64) (Hash, error)) (int64, error) {
+	bcHeight, err := RpcGetblockcount()
+	if err != nil {
+		return 0, err
+	}
+	//A node behind the index is still loading or syncing its chain.
+	if bcHeight < tipHeight {
+		return tipHeight, nil
+	}
+	for height := tipHeight; height >= 0 && tipHeight-height <= maxReorgDepth; height-- {
+		hash, err := indexedHash(height)
+		if err != nil {
+			return 0, err
+		}
+		nodeHash, err := RpcGetblockhash(height)
+		if err != nil {
+			return 0, err
+		}
+		if nodeHash == hash.String() {
+			return height, nil
+		}
+	}
+	return 0, ErrReorgTooDeep
+}
+
+func (ix *indexer) indexedHash(height int64) (Hash, error) {
+	block, err := ix.queries.BlockByHeight(height)
+	if err != nil {
+		return Hash{}, err
+	}
+	return block.Hash, nil
+}
+
+// rollBack disconnects the indexed blocks bitcoind left in a reorg, tip
+// first. Their txs return to the mempool, those bitcoind dropped, such as
+// conflicts with its new branch, are removed right away so the new branch
+// connects onto unspent outputs. Every disconnected block is announced with
+// the txs it returned.
+func (ix *indexer) rollBack() error {
+	tip, err := ix.tip()
+	if tip == nil || err != nil {
+		return err
+	}
+	fork, err := forkHeight(tip.Height, ix.indexedHash)
+	if err != nil {
+		return err
+	}
+	if fork == tip.Height {
+		return nil
+	}
+	log.Warning("Reorg at height %d, disconnecting %d blocks.", fork, tip.Height-fork)
+	if err := ix.cache.Flush(ix.dbmap); err != nil {
+		return err
+	}
+	var disconnected []*ModelBlock
+	returned := make(map[Hash][]*ModelTx)
+	for height := tip.Height; height > fork; height-- {
+		block, err := ix.queries.BlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		trans, err := ix.dbmap.Begin()
+		if err != nil {
+			return err
+		}
+		txs, gone, err := block.DeleteFromDb(trans)
+		if err != nil {
+			trans.Rollback()
+			return err
+		}
+		if err := RewindWebhookMark(trans, height-1); err != nil {
+			trans.Rollback()
+			return err
+		}
+		if err := trans.Commit(); err != nil {
+			return err
+		}
+		for _, op := range gone {
+			ix.cache.Forget(op)
+		}
+		disconnected = append(disconnected, block)
+		returned[block.Hash] = txs
+	}
+	IndexChanged()
+	txids, err := RpcGetrawmempoolOrdered()
+	if err != nil {
+		return err
+	}
+	inNode := make(map[string]bool, len(txids))
+	for _, txid := range txids {
+		inNode[txid] = true
+	}
+	var dropped []Hash
+	for _, block := range disconnected {
+		var kept []string
+		for _, tx := range returned[block.Hash] {
+			if inNode[tx.Hash.String()] {
+				kept = append(kept, tx.Hash.String())
+			} else {
+				dropped = append(dropped, tx.Hash)
+			}
+		}
+		notify.Publish(&notify.Event{Type: notify.BlockDisconnected, Hash: block.Hash.String(), Height: block.Height, Txs: kept})
+	}
+	for _, hash := range dropped {
+		if err := ix.removeTx(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUp indexes everything bitcoind has beyond the DB tip over RPC, the
+// same way -buildblock does, after rolling back blocks it left in a reorg.
+func (ix *indexer) catchUp() error {
+	if err := ix.cache.Flush(ix.dbmap); err != nil {
+		return err
+	}
+	if err := ix.rollBack(); err != nil {
+		return err
+	}
+	before, _ := GetMaxBlockHeightFromDB(ix.dbmap)
+	//Blocks inserted before a failure are still announced.
+	buildErr := buildBlock(ix.dbmap, ix.queries, 0)
+	buildTxFromBlock(ix.dbmap)
+	extractTx(ix.dbmap)
+	ix.resolve()
+	ix.queueWebhooks()
+	after, _ := GetMaxBlockHeightFromDB(ix.dbmap)
+	from := before + 1
+	if from < after-maxCatchUpEvents+1 {
+		from = after - maxCatchUpEvents + 1
+	}
+	for height := from; height <= after; height++ {
+		block, err := ix.queries.BlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		ix.publishBlock(block)
+	}
+	return buildErr
+}
+
+// fetchTx indexes a mempool tx known only by hash.
+func (ix *indexer) fetchTx(hash Hash) error {
+	if known, err := ix.hasTx(hash); known || err != nil {
+		return err
+	}
+	raw, err := RpcGetrawtransaction(hash.String())
+	if err != nil {
+		if e, ok := err.(*RpcError); ok && e.Code == RpcErrInvalidAddressOrKey {
+			//Mined or evicted meanwhile.
+			return nil
+		}
+		return err
+	}
+	tx := new(ModelTx)
+	if err := tx.NewFromString(raw); err != nil {
+		return err
+	}
+	return ix.storeTx(tx)
+}
+
+// publishBlock announces a connected block and, if anyone listens, its txs
+// with their addresses.
+func (ix *indexer) publishBlock(block *ModelBlock) {
+	if !notify.HasSubscribers() {
+		return
+	}
+	var txs []*ModelTx
+	_, err := ix.dbmap.Select(&txs, "select tx.* from tx join blocktx on blocktx.TxId=tx.Id where blocktx.BlockId=? order by blocktx.Id", block.Id)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	addresses, err := AddressesOfBlockTxs(ix.dbmap, block.Id)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	blockHash := block.Hash.String()
+	notify.Publish(&notify.Event{
+		Type:    notify.BlockConnected,
+		Hash:    blockHash,
+		Height:  block.Height,
+		Time:    block.Time.Unix(),
+		TxCount: int64(len(txs)),
+	})
+	for _, tx := range txs {
+		notify.Publish(&notify.Event{
+			Type:        notify.TxAdded,
+			Hash:        tx.Hash.String(),
+			BlockHash:   blockHash,
+			BlockHeight: block.Height,
+			Addresses:   addresses[tx.Hash],
+		})
+	}
+}
+
+// queueWebhooks fills the webhook outbox up to the index. Unlike events
+// nothing is lost when it fails, the next call queues what is left.
+func (ix *indexer) queueWebhooks() {
+	if err := QueueWebhooks(ix.dbmap); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// publishTx announces a mempool tx.
+func (ix *indexer) publishTx(tx *ModelTx) {
+	if !notify.HasSubscribers() {
+		return
+	}
+	addresses, err := AddressesOfTx(ix.dbmap, tx.Id)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	notify.Publish(&notify.Event{Type: notify.TxAdded, Hash: tx.Hash.String(), Addresses: addresses})
+}
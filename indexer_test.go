@@ -0,0 +1,176 @@
+package main
+
+import (
+	. "Assange/bitcoinrpc"
+	. "Assange/blockdata"
+	"Assange/config"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/conformal/btcwire"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testNode answers the RPC calls of the indexer from an in-memory chain.
+type testNode struct {
+	chain   []*btcwire.MsgBlock
+	mempool []*btcwire.MsgTx
+}
+
+func startTestNode(t *testing.T, node *testNode) *httptest.Server {
+	ts := httptest.NewServer(node)
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+	if err := InitRpcClient(config.Configuration{Rpc_host: u.Hostname(), Rpc_port: port}); err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+		Id     int32         `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, rpcErr := n.call(req.Method, req.Params)
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": rpcErr, "id": req.Id})
+}
+
+func (n *testNode) call(method string, params []interface{}) (interface{}, *RpcError) {
+	switch method {
+	case "getblockcount":
+		return len(n.chain) - 1, nil
+	case "getblockhash":
+		height := int(params[0].(float64))
+		if height < 0 || height >= len(n.chain) {
+			return nil, &RpcError{Code: RpcErrInvalidParameter, Message: "Block height out of range"}
+		}
+		return testBlockHash(n.chain[height]), nil
+	case "getblock":
+		for height, block := range n.chain {
+			if testBlockHash(block) == params[0].(string) {
+				return n.verboseBlock(height), nil
+			}
+		}
+		return nil, &RpcError{Code: RpcErrInvalidAddressOrKey, Message: "Block not found"}
+	case "getrawmempool":
+		entries := make(map[string]*MempoolEntry)
+		for _, tx := range n.mempool {
+			entries[testTxHash(tx)] = &MempoolEntry{Depends: []string{}}
+		}
+		return entries, nil
+	}
+	return nil, &RpcError{Code: RpcErrMethodNotFound, Message: "Method not found"}
+}
+
+func (n *testNode) verboseBlock(height int) map[string]interface{} {
+	block := n.chain[height]
+	var txs []BlockTx
+	for _, tx := range block.Transactions {
+		var buf bytes.Buffer
+		tx.Serialize(&buf)
+		hash := testTxHash(tx)
+		txs = append(txs, BlockTx{Txid: hash, Hash: hash, Hex: hex.EncodeToString(buf.Bytes())})
+	}
+	result := map[string]interface{}{
+		"hash":       testBlockHash(block),
+		"height":     height,
+		"version":    block.Header.Version,
+		"merkleroot": Hash{}.String(),
+		"time":       block.Header.Timestamp.Unix(),
+		"nonce":      block.Header.Nonce,
+		"bits":       fmt.Sprintf("%08x", block.Header.Bits),
+		"tx":         txs,
+	}
+	if height > 0 {
+		result["previousblockhash"] = testBlockHash(n.chain[height-1])
+	}
+	if height+1 < len(n.chain) {
+		result["nextblockhash"] = testBlockHash(n.chain[height+1])
+	}
+	return result
+}
+
+func testBlockHash(block *btcwire.MsgBlock) string {
+	sha, _ := block.BlockSha()
+	return NewHashFromSha(&sha).String()
+}
+
+func testTxHash(tx *btcwire.MsgTx) string {
+	sha, _ := tx.TxSha()
+	return NewHashFromSha(&sha).String()
+}
+
+// testBlock builds a block on prev with a coinbase paying to a script
+// depending on branch, so blocks of different branches differ.
+func testBlock(prev *btcwire.MsgBlock, branch byte, txs ...*btcwire.MsgTx) *btcwire.MsgBlock {
+	block := new(btcwire.MsgBlock)
+	block.Header.Version = 2
+	block.Header.Bits = 0x207fffff
+	block.Header.Nonce = uint32(branch)
+	block.Header.Timestamp = time.Unix(1400000000, 0)
+	if prev != nil {
+		block.Header.PrevBlock, _ = prev.BlockSha()
+		block.Header.Timestamp = prev.Header.Timestamp.Add(10 * time.Minute)
+	}
+	pkScript := append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{branch}, 20)...)
+	pkScript = append(pkScript, 0x88, 0xac)
+	coinbase := &btcwire.MsgTx{
+		Version: 1,
+		TxIn: []*btcwire.TxIn{{
+			PreviousOutPoint: btcwire.OutPoint{Index: btcwire.MaxPrevOutIndex},
+			SignatureScript:  []byte{0x04, byte(block.Header.Timestamp.Unix()), 0, 0, branch},
+			Sequence:         0xffffffff,
+		}},
+		TxOut: []*btcwire.TxOut{{Value: 5000000000, PkScript: pkScript}},
+	}
+	block.Transactions = append([]*btcwire.MsgTx{coinbase}, txs...)
+	return block
+}
+
+func TestForkHeight01(t *testing.T) {
+	genesis := testBlock(nil, 0)
+	a1 := testBlock(genesis, 'a')
+	a2 := testBlock(a1, 'a')
+	b1 := testBlock(genesis, 'b')
+	b2 := testBlock(b1, 'b')
+	b3 := testBlock(b2, 'b')
+	c0 := testBlock(nil, 'c')
+	c1 := testBlock(c0, 'c')
+	c2 := testBlock(c1, 'c')
+	indexed := []*btcwire.MsgBlock{genesis, a1, a2}
+	indexedHash := func(height int64) (Hash, error) {
+		return NewHashFromStr(testBlockHash(indexed[height]))
+	}
+	cases := []struct {
+		chain []*btcwire.MsgBlock
+		fork  int64
+		err   error
+	}{
+		{[]*btcwire.MsgBlock{genesis, a1, a2}, 2, nil},
+		{[]*btcwire.MsgBlock{genesis, a1, a2, testBlock(a2, 'a')}, 2, nil},
+		{[]*btcwire.MsgBlock{genesis, b1, b2, b3}, 0, nil},
+		{[]*btcwire.MsgBlock{genesis, a1}, 2, nil},
+		{[]*btcwire.MsgBlock{c0, c1, c2}, 0, ErrReorgTooDeep},
+	}
+	for i, c := range cases {
+		ts := startTestNode(t, &testNode{chain: c.chain})
+		fork, err := forkHeight(2, indexedHash)
+		ts.Close()
+		if err != c.err || (err == nil && fork != c.fork) {
+			t.Errorf("Case %d: got fork %d, %v, expected %d, %v.", i, fork, err, c.fork, c.err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	. "Assange/logging"
+	"sync"
+)
+
+var log = GetLogger("Notify", DEBUG)
+
+// Event types.
+const (
+	BlockConnected    = "block_connected"
+	BlockDisconnected = "block_disconnected"
+	TxAdded           = "tx"
+	TxRemoved         = "tx_removed"
+)
+
+// Event is something the indexer did, as pushed to subscribers. Block
+// fields of tx events are set once the tx is confirmed. Txs lists the txs a
+// disconnected block returned to the mempool.
+type Event struct {
+	Type        string   `json:"type"`
+	Hash        string   `json:"hash"`
+	Height      int64    `json:"height,omitempty"`
+	Time        int64    `json:"time,omitempty"`
+	TxCount     int64    `json:"tx_count,omitempty"`
+	BlockHash   string   `json:"block_hash,omitempty"`
+	BlockHeight int64    `json:"block_height,omitempty"`
+	Addresses   []string `json:"addresses,omitempty"`
+	Txs         []string `json:"txs,omitempty"`
+}
+
+// Subscription receives the published events its filter selects on C until
+// it is closed. Events are never waited for: a subscriber whose buffer is
+// full is dropped and C is closed.
+type Subscription struct {
+	C      <-chan *Event
+	c      chan *Event
+	filter func(*Event) bool
+	once   sync.Once
+}
+
+var mutex sync.RWMutex
+var subscriptions = make(map[*Subscription]bool)
+
+// Subscribe registers for the events filter selects, all of them if it is
+// nil. filter runs on the publisher's goroutine, so the events of a large
+// block only fill the buffers of the subscribers they concern.
+func Subscribe(buffer int, filter func(*Event) bool) *Subscription {
+	c := make(chan *Event, buffer)
+	s := &Subscription{C: c, c: c, filter: filter}
+	mutex.Lock()
+	subscriptions[s] = true
+	mutex.Unlock()
+	return s
+}
+
+func (s *Subscription) Close() {
+	mutex.Lock()
+	delete(subscriptions, s)
+	mutex.Unlock()
+	s.once.Do(func() { close(s.c) })
+}
+
+// HasSubscribers tells publishers whether building events is worth it.
+func HasSubscribers() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return len(subscriptions) > 0
+}
+
+func Publish(e *Event) {
+	var slow []*Subscription
+	mutex.RLock()
+	for s := range subscriptions {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	mutex.RUnlock()
+	for _, s := range slow {
+		log.Warning("Subscriber too slow, dropped.")
+		s.Close()
+	}
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"testing"
+)
+
+func TestPublish01(t *testing.T) {
+	a, b := Subscribe(1, nil), Subscribe(1, nil)
+	defer a.Close()
+	if !HasSubscribers() {
+		t.Fatal("Subscribers not registered.")
+	}
+	Publish(&Event{Type: BlockConnected, Hash: "00"})
+	for _, s := range []*Subscription{a, b} {
+		if e := <-s.C; e.Type != BlockConnected {
+			t.Errorf("Got %+v.", e)
+		}
+	}
+	b.Close()
+	b.Close()
+	Publish(&Event{Type: TxAdded, Hash: "01"})
+	if e := <-a.C; e.Hash != "01" {
+		t.Errorf("Got %+v.", e)
+	}
+}
+
+func TestSlowSubscriber01(t *testing.T) {
+	s := Subscribe(1, nil)
+	Publish(&Event{Type: TxAdded, Hash: "01"})
+	Publish(&Event{Type: TxAdded, Hash: "02"})
+	if e := <-s.C; e.Hash != "01" {
+		t.Errorf("Got %+v.", e)
+	}
+	if _, ok := <-s.C; ok {
+		t.Error("Slow subscriber not dropped.")
+	}
+	if HasSubscribers() {
+		t.Error("Dropped subscriber still registered.")
+	}
+}
+
+func TestFilteredSubscriber01(t *testing.T) {
+	s := Subscribe(1, func(e *Event) bool { return e.Hash == "02" })
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		Publish(&Event{Type: TxAdded, Hash: "01"})
+	}
+	Publish(&Event{Type: TxAdded, Hash: "02"})
+	if e, ok := <-s.C; !ok || e.Hash != "02" {
+		t.Errorf("Got %+v.", e)
+	}
+}
@@ -0,0 +1,134 @@
+package p2p
+
+import (
+	. "Assange/logging"
+	"errors"
+	"github.com/conformal/btcwire"
+	"net"
+	"time"
+)
+
+var log = GetLogger("P2P", DEBUG)
+
+const (
+	userAgentName    = "Assange"
+	userAgentVersion = "0.1"
+
+	dialTimeout      = 30 * time.Second
+	handshakeTimeout = 30 * time.Second
+	writeTimeout     = 30 * time.Second
+	//bitcoind pings every 2 minutes, a quiet connection is dead.
+	idleTimeout = 5 * time.Minute
+)
+
+var ErrUnexpectedMessage = errors.New("Unexpected message during version handshake.")
+
+// Peer is a connection to one node speaking the bitcoin wire protocol.
+type Peer struct {
+	conn net.Conn
+	net  btcwire.BitcoinNet
+	pver uint32
+
+	//Height the peer announced in its version message.
+	LastBlock int32
+	UserAgent string
+}
+
+// Dial connects to addr, host:port of a bitcoin node.
+func Dial(addr string, bnet btcwire.BitcoinNet) (*Peer, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeer(conn, bnet), nil
+}
+
+func NewPeer(conn net.Conn, bnet btcwire.BitcoinNet) *Peer {
+	return &Peer{conn: conn, net: bnet, pver: btcwire.ProtocolVersion}
+}
+
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+func (p *Peer) WriteMessage(msg btcwire.Message) error {
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return btcwire.WriteMessage(p.conn, msg, p.pver, p.net)
+}
+
+// ReadMessage returns the next message of interest. Pings are answered and
+// commands btcwire does not know are skipped on the way.
+func (p *Peer) ReadMessage() (btcwire.Message, error) {
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		msg, _, err := btcwire.ReadMessage(p.conn, p.pver, p.net)
+		if err != nil {
+			if _, ok := err.(*btcwire.MessageError); ok {
+				log.Debug("Skip message from %s: %s", p.Addr(), err.Error())
+				continue
+			}
+			return nil, err
+		}
+		if ping, ok := msg.(*btcwire.MsgPing); ok {
+			if err := p.WriteMessage(btcwire.NewMsgPong(ping.Nonce)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// Handshake exchanges version and verack messages. lastBlock is our own
+// best height, announced to the peer.
+func (p *Peer) Handshake(lastBlock int32) error {
+	nonce, err := btcwire.RandomUint64()
+	if err != nil {
+		return err
+	}
+	me := btcwire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+	you, err := btcwire.NewNetAddress(p.conn.RemoteAddr(), btcwire.SFNodeNetwork)
+	if err != nil {
+		you = btcwire.NewNetAddressIPPort(net.IPv4zero, 0, btcwire.SFNodeNetwork)
+	}
+	version := btcwire.NewMsgVersion(me, you, nonce, lastBlock)
+	version.AddUserAgent(userAgentName, userAgentVersion)
+	if err := p.WriteMessage(version); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(handshakeTimeout)
+	gotVersion, gotVerAck := false, false
+	for !gotVersion || !gotVerAck {
+		p.conn.SetReadDeadline(deadline)
+		msg, _, err := btcwire.ReadMessage(p.conn, p.pver, p.net)
+		if err != nil {
+			if _, ok := err.(*btcwire.MessageError); ok {
+				continue
+			}
+			return err
+		}
+		switch m := msg.(type) {
+		case *btcwire.MsgVersion:
+			if uint32(m.ProtocolVersion) < p.pver {
+				p.pver = uint32(m.ProtocolVersion)
+			}
+			p.LastBlock = m.LastBlock
+			p.UserAgent = m.UserAgent
+			gotVersion = true
+			if err := p.WriteMessage(btcwire.NewMsgVerAck()); err != nil {
+				return err
+			}
+		case *btcwire.MsgVerAck:
+			gotVerAck = true
+		default:
+			return ErrUnexpectedMessage
+		}
+	}
+	log.Info("Connected to %s %s, height %d.", p.Addr(), p.UserAgent, p.LastBlock)
+	return nil
+}
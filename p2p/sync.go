@@ -0,0 +1,207 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/conformal/btcwire"
+)
+
+var ErrHeadersNotConnected = errors.New("Headers from peer do not connect to the indexed chain.")
+var ErrBlockOutOfOrder = errors.New("Block from peer arrived out of order.")
+var ErrBlockNotFound = errors.New("Peer does not have a requested block.")
+
+// Handler receives what a Syncer downloads. Blocks are passed in chain
+// order, each on top of the previous one.
+type Handler interface {
+	//Tip returns the height and hash of the last indexed block, or -1 and
+	//nil when nothing is indexed yet.
+	Tip() (int64, *btcwire.ShaHash, error)
+	HandleBlock(height int64, block *btcwire.MsgBlock) error
+	HandleTx(tx *btcwire.MsgTx) error
+}
+
+// Syncer downloads the chain from one peer headers first: getheaders from
+// the indexed tip, getdata for the announced blocks, and again until the
+// peer has no more headers. Afterwards inv announcements of new blocks and
+// txs are followed.
+type Syncer struct {
+	peer    *Peer
+	handler Handler
+	genesis *btcwire.ShaHash
+
+	height     int64
+	headerTip  btcwire.ShaHash
+	pending    []btcwire.ShaHash
+	askingHead bool
+	moreHeads  bool
+}
+
+func NewSyncer(peer *Peer, handler Handler, genesis *btcwire.ShaHash) *Syncer {
+	return &Syncer{peer: peer, handler: handler, genesis: genesis}
+}
+
+// Synced reports whether the indexed chain has caught up with the peer.
+func (s *Syncer) Synced() bool {
+	return !s.moreHeads && !s.askingHead && len(s.pending) == 0
+}
+
+// Run syncs until the connection fails or the handler returns an error.
+func (s *Syncer) Run() error {
+	height, hash, err := s.handler.Tip()
+	if err != nil {
+		return err
+	}
+	if err := s.peer.Handshake(int32(height)); err != nil {
+		return err
+	}
+	s.height = height
+	if hash == nil {
+		//Headers never include the genesis block, fetch it directly.
+		s.headerTip = *s.genesis
+		if err := s.getBlocks([]btcwire.ShaHash{*s.genesis}); err != nil {
+			return err
+		}
+	} else {
+		s.headerTip = *hash
+	}
+	if err := s.getHeaders(); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := s.peer.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case *btcwire.MsgHeaders:
+			err = s.handleHeaders(m)
+		case *btcwire.MsgBlock:
+			err = s.handleBlock(m)
+		case *btcwire.MsgInv:
+			err = s.handleInv(m)
+		case *btcwire.MsgTx:
+			err = s.handler.HandleTx(m)
+		case *btcwire.MsgNotFound:
+			for _, iv := range m.InvList {
+				if iv.Type == btcwire.InvTypeBlock {
+					return ErrBlockNotFound
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Syncer) getHeaders() error {
+	if s.askingHead {
+		s.moreHeads = true
+		return nil
+	}
+	msg := btcwire.NewMsgGetHeaders()
+	tip := s.headerTip
+	msg.AddBlockLocatorHash(&tip)
+	s.askingHead = true
+	return s.peer.WriteMessage(msg)
+}
+
+func (s *Syncer) getBlocks(hashes []btcwire.ShaHash) error {
+	msg := btcwire.NewMsgGetData()
+	for i := range hashes {
+		msg.AddInvVect(btcwire.NewInvVect(btcwire.InvTypeBlock, &hashes[i]))
+	}
+	s.pending = append(s.pending, hashes...)
+	return s.peer.WriteMessage(msg)
+}
+
+func (s *Syncer) handleHeaders(msg *btcwire.MsgHeaders) error {
+	s.askingHead = false
+	var hashes []btcwire.ShaHash
+	for _, header := range msg.Headers {
+		if !header.PrevBlock.IsEqual(&s.headerTip) {
+			//The peer is on another branch, reorgs are not followed.
+			log.Error("Header %s does not connect to %s.", header.PrevBlock, s.headerTip)
+			return ErrHeadersNotConnected
+		}
+		hash, err := header.BlockSha()
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+		s.headerTip = hash
+	}
+	full := len(msg.Headers) == btcwire.MaxBlockHeadersPerMsg
+	s.moreHeads = s.moreHeads || full
+	if len(hashes) > 0 {
+		log.Info("Got %d headers, request blocks up to height %d.", len(hashes), s.height+int64(len(s.pending)+len(hashes)))
+		return s.getBlocks(hashes)
+	}
+	if s.moreHeads {
+		s.moreHeads = false
+		return s.getHeaders()
+	}
+	return nil
+}
+
+func (s *Syncer) isPending(hash *btcwire.ShaHash) bool {
+	for i := range s.pending {
+		if s.pending[i].IsEqual(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Syncer) handleBlock(msg *btcwire.MsgBlock) error {
+	hash, err := msg.BlockSha()
+	if err != nil {
+		return err
+	}
+	if len(s.pending) == 0 || !s.pending[0].IsEqual(&hash) {
+		if s.isPending(&hash) {
+			return ErrBlockOutOfOrder
+		}
+		log.Debug("Ignore unrequested block %s.", hash)
+		return nil
+	}
+	if err := s.handler.HandleBlock(s.height+1, msg); err != nil {
+		return err
+	}
+	s.height++
+	s.pending = s.pending[1:]
+	if len(s.pending) == 0 {
+		if s.moreHeads {
+			s.moreHeads = false
+			return s.getHeaders()
+		}
+		log.Info("Synced to height %d.", s.height)
+	}
+	return nil
+}
+
+func (s *Syncer) handleInv(msg *btcwire.MsgInv) error {
+	getData := btcwire.NewMsgGetData()
+	for _, iv := range msg.InvList {
+		switch iv.Type {
+		case btcwire.InvTypeBlock:
+			if len(s.pending) > 0 {
+				//Asked for once the pending blocks are in.
+				s.moreHeads = true
+				continue
+			}
+			if err := s.getHeaders(); err != nil {
+				return err
+			}
+		case btcwire.InvTypeTx:
+			//Txs spending outputs we have not indexed yet are of no use.
+			if s.Synced() {
+				getData.AddInvVect(iv)
+			}
+		}
+	}
+	if len(getData.InvList) == 0 {
+		return nil
+	}
+	return s.peer.WriteMessage(getData)
+}
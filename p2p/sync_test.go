@@ -0,0 +1,178 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/conformal/btcwire"
+	"net"
+	"testing"
+	"time"
+)
+
+var errDone = errors.New("done")
+
+// testChain builds n linked block headers starting at a genesis block.
+func testChain(n int) []*btcwire.MsgBlock {
+	var blocks []*btcwire.MsgBlock
+	var prev btcwire.ShaHash
+	for i := 0; i < n; i++ {
+		block := &btcwire.MsgBlock{Header: btcwire.BlockHeader{
+			Version:   1,
+			PrevBlock: prev,
+			Timestamp: time.Unix(1231006505+int64(i)*600, 0),
+			Nonce:     uint32(i),
+		}}
+		block.Transactions = []*btcwire.MsgTx{{Version: 1, LockTime: uint32(i)}}
+		prev, _ = block.BlockSha()
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// standInPeer answers the handshake, getheaders and getdata from chain,
+// then announces mempool.
+func standInPeer(t *testing.T, ln net.Listener, chain []*btcwire.MsgBlock, mempool *btcwire.MsgTx) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	p := NewPeer(conn, btcwire.MainNet)
+	announced := false
+	for {
+		msg, err := p.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch m := msg.(type) {
+		case *btcwire.MsgVersion:
+			me := btcwire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+			p.WriteMessage(btcwire.NewMsgVersion(me, me, 1, int32(len(chain)-1)))
+			p.WriteMessage(btcwire.NewMsgVerAck())
+		case *btcwire.MsgGetHeaders:
+			headers := btcwire.NewMsgHeaders()
+			found := false
+			for _, block := range chain {
+				if found {
+					headers.AddBlockHeader(&block.Header)
+				}
+				hash, _ := block.BlockSha()
+				found = found || hash.IsEqual(m.BlockLocatorHashes[0])
+			}
+			p.WriteMessage(headers)
+		case *btcwire.MsgGetData:
+			for _, iv := range m.InvList {
+				if iv.Type == btcwire.InvTypeTx {
+					p.WriteMessage(mempool)
+					continue
+				}
+				for _, block := range chain {
+					if hash, _ := block.BlockSha(); hash.IsEqual(&iv.Hash) {
+						p.WriteMessage(block)
+					}
+				}
+				last, _ := chain[len(chain)-1].BlockSha()
+				if iv.Hash.IsEqual(&last) && mempool != nil && !announced {
+					announced = true
+					inv := btcwire.NewMsgInv()
+					txHash, _ := mempool.TxSha()
+					inv.AddInvVect(btcwire.NewInvVect(btcwire.InvTypeTx, &txHash))
+					p.WriteMessage(inv)
+				}
+			}
+		}
+	}
+}
+
+type testHandler struct {
+	height  int64
+	hash    *btcwire.ShaHash
+	heights []int64
+	hashes  []btcwire.ShaHash
+	stopAt  int64
+	txs     int
+}
+
+func (h *testHandler) Tip() (int64, *btcwire.ShaHash, error) {
+	return h.height, h.hash, nil
+}
+
+func (h *testHandler) HandleBlock(height int64, block *btcwire.MsgBlock) error {
+	hash, _ := block.BlockSha()
+	h.heights = append(h.heights, height)
+	h.hashes = append(h.hashes, hash)
+	if height == h.stopAt {
+		return errDone
+	}
+	return nil
+}
+
+func (h *testHandler) HandleTx(tx *btcwire.MsgTx) error {
+	h.txs++
+	return errDone
+}
+
+func runSyncer(t *testing.T, chain []*btcwire.MsgBlock, mempool *btcwire.MsgTx, handler *testHandler) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go standInPeer(t, ln, chain, mempool)
+
+	peer, err := Dial(ln.Addr().String(), btcwire.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	genesis, _ := chain[0].BlockSha()
+	return NewSyncer(peer, handler, &genesis).Run()
+}
+
+func TestSyncFromGenesis01(t *testing.T) {
+	chain := testChain(4)
+	mempool := &btcwire.MsgTx{Version: 1, LockTime: 99}
+	handler := &testHandler{height: -1, stopAt: -1}
+
+	if err := runSyncer(t, chain, mempool, handler); err != errDone {
+		t.Fatalf("Expected to stop at the mempool tx, got %v.", err)
+	}
+	if len(handler.heights) != len(chain) {
+		t.Fatalf("Got %d blocks, expected %d.", len(handler.heights), len(chain))
+	}
+	for i, block := range chain {
+		hash, _ := block.BlockSha()
+		if handler.heights[i] != int64(i) || !handler.hashes[i].IsEqual(&hash) {
+			t.Errorf("Block %d: got height %d hash %s.", i, handler.heights[i], handler.hashes[i])
+		}
+	}
+	if handler.txs != 1 {
+		t.Errorf("Got %d txs, expected 1.", handler.txs)
+	}
+}
+
+func TestSyncFromTip01(t *testing.T) {
+	chain := testChain(4)
+	tip, _ := chain[1].BlockSha()
+	handler := &testHandler{height: 1, hash: &tip, stopAt: 3}
+
+	if err := runSyncer(t, chain, nil, handler); err != errDone {
+		t.Fatalf("Expected to stop at height 3, got %v.", err)
+	}
+	if len(handler.heights) != 2 || handler.heights[0] != 2 || handler.heights[1] != 3 {
+		t.Errorf("Got heights %v, expected [2 3].", handler.heights)
+	}
+}
+
+func TestSyncOtherBranch01(t *testing.T) {
+	chain := testChain(4)
+	fork := testChain(3)
+	fork[2].Header.PrevBlock = btcwire.ShaHash{1}
+	//Our tip is block 1, the peer's block 2 builds on something else.
+	chain[2] = fork[2]
+	tip, _ := chain[1].BlockSha()
+	handler := &testHandler{height: 1, hash: &tip, stopAt: -1}
+
+	if err := runSyncer(t, chain, nil, handler); err != ErrHeadersNotConnected {
+		t.Errorf("Expected ErrHeadersNotConnected, got %v.", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	. "Assange/blockdata"
+	"Assange/p2p"
+	"github.com/conformal/btcwire"
+	"time"
+)
+
+// Wait before reconnecting to the P2P peer.
+const p2pReconnectDelay = 30 * time.Second
+
+// p2pHandler feeds the indexer from the P2P syncer.
+type p2pHandler struct {
+	*indexer
+}
+
+func (h *p2pHandler) Tip() (int64, *btcwire.ShaHash, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	block, err := h.tip()
+	if block == nil || err != nil {
+		return -1, nil, err
+	}
+	return block.Height, block.Hash.ShaHash(), nil
+}
+
+func (h *p2pHandler) HandleBlock(height int64, msg *btcwire.MsgBlock) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.indexBlock(height, msg)
+}
+
+func (h *p2pHandler) HandleTx(msg *btcwire.MsgTx) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.indexTx(msg)
+}
+
+// syncP2p follows the peer in Config.P2p_peer, reconnecting whenever the
+// connection drops.
+func syncP2p(ix *indexer) {
+	params := NetParams()
+	handler := &p2pHandler{ix}
+	for {
+		peer, err := p2p.Dial(Config.P2p_peer, params.Net)
+		if err != nil {
+			log.Error("Connect to %s failed: %s", Config.P2p_peer, err.Error())
+		} else {
+			err = p2p.NewSyncer(peer, handler, params.GenesisHash).Run()
+			peer.Close()
+			log.Error("P2P sync from %s stopped: %s", Config.P2p_peer, err.Error())
+		}
+		time.Sleep(p2pReconnectDelay)
+	}
+}
@@ -0,0 +1,148 @@
+package raw
+
+import (
+	. "Assange/blockdata"
+	"bytes"
+	"errors"
+	"github.com/conformal/btcwire"
+	"io"
+)
+
+// Key types of BIP174 used when decoding.
+const (
+	psbtGlobalUnsignedTx = 0x00
+	psbtInNonWitnessUtxo = 0x00
+	psbtInWitnessUtxo    = 0x01
+	psbtInFinalScriptSig = 0x07
+)
+
+// Keys and values are far smaller in any sane PSBT.
+const psbtMaxItemSize = 4000000
+
+var psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+var ErrNotPsbt = errors.New("Data is not a PSBT.")
+var ErrPsbtNoTx = errors.New("PSBT has no unsigned tx.")
+var ErrPsbtInputs = errors.New("PSBT input maps do not match the tx inputs.")
+
+// Psbt is the part of a BIP174 partially signed tx needed to show it: the
+// unsigned tx and, per input, the spent output and final scriptSig if the
+// signer supplied them.
+type Psbt struct {
+	Tx     *btcwire.MsgTx
+	Inputs []*PsbtInput
+}
+
+type PsbtInput struct {
+	Utxo           *btcwire.TxOut
+	FinalScriptSig []byte
+}
+
+func IsPsbt(data []byte) bool {
+	return bytes.HasPrefix(data, psbtMagic)
+}
+
+func DecodePsbt(data []byte) (*Psbt, error) {
+	if !IsPsbt(data) {
+		return nil, ErrNotPsbt
+	}
+	r := bytes.NewReader(data[len(psbtMagic):])
+	p := new(Psbt)
+	err := readPsbtMap(r, func(key, value []byte) error {
+		if key[0] != psbtGlobalUnsignedTx {
+			return nil
+		}
+		p.Tx = new(btcwire.MsgTx)
+		return p.Tx.Deserialize(bytes.NewReader(value))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.Tx == nil {
+		return nil, ErrPsbtNoTx
+	}
+	for _, txin := range p.Tx.TxIn {
+		in := new(PsbtInput)
+		prev := txin.PreviousOutPoint
+		err := readPsbtMap(r, func(key, value []byte) error {
+			switch key[0] {
+			case psbtInNonWitnessUtxo:
+				//The previous tx may be serialized with its witness.
+				prevTx, _, err := DecodeMsgTx(value)
+				if err != nil {
+					return err
+				}
+				if int(prev.Index) < len(prevTx.TxOut) {
+					in.Utxo = prevTx.TxOut[prev.Index]
+				}
+			case psbtInWitnessUtxo:
+				//Only taken when no full previous tx is given.
+				if in.Utxo == nil {
+					utxo, err := readTxOut(value)
+					if err != nil {
+						return err
+					}
+					in.Utxo = utxo
+				}
+			case psbtInFinalScriptSig:
+				in.FinalScriptSig = value
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, ErrPsbtInputs
+		}
+		p.Inputs = append(p.Inputs, in)
+	}
+	return p, nil
+}
+
+// readPsbtMap calls fn for every key-value pair up to the 0x00 separator.
+func readPsbtMap(r *bytes.Reader, fn func(key, value []byte) error) error {
+	for {
+		key, err := readPsbtItem(r)
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		value, err := readPsbtItem(r)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+func readPsbtItem(r *bytes.Reader) ([]byte, error) {
+	size, err := btcwire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if size > psbtMaxItemSize || size > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	item := make([]byte, size)
+	_, err = io.ReadFull(r, item)
+	return item, err
+}
+
+// readTxOut decodes a serialized output, 8 byte value and script.
+func readTxOut(data []byte) (*btcwire.TxOut, error) {
+	if len(data) < 9 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var value int64
+	for i := 7; i >= 0; i-- {
+		value = value<<8 | int64(data[i])
+	}
+	r := bytes.NewReader(data[8:])
+	script, err := readPsbtItem(r)
+	if err != nil {
+		return nil, err
+	}
+	return &btcwire.TxOut{Value: value, PkScript: script}, nil
+}
@@ -0,0 +1,91 @@
+package raw
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+func psbtItem(buf *bytes.Buffer, data []byte) {
+	btcwire.WriteVarInt(buf, 0, uint64(len(data)))
+	buf.Write(data)
+}
+
+func serializeTx(tx *btcwire.MsgTx) []byte {
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	return buf.Bytes()
+}
+
+// testPsbt spends output 1 of a full previous tx and a witness output.
+func testPsbt() (*btcwire.MsgTx, []byte) {
+	prevTx := &btcwire.MsgTx{Version: 1, TxOut: []*btcwire.TxOut{{Value: 1, PkScript: []byte{0x51}}, {Value: 70000, PkScript: []byte{0x52}}}}
+	prevHash, _ := prevTx.TxSha()
+	tx := &btcwire.MsgTx{
+		Version: 2,
+		TxIn: []*btcwire.TxIn{
+			{PreviousOutPoint: btcwire.OutPoint{Hash: prevHash, Index: 1}, Sequence: 0xffffffff},
+			{PreviousOutPoint: btcwire.OutPoint{Hash: btcwire.ShaHash{7}, Index: 0}, Sequence: 0xffffffff},
+		},
+		TxOut: []*btcwire.TxOut{{Value: 90000, PkScript: []byte{0x53}}},
+	}
+
+	var buf bytes.Buffer
+	buf.Write(psbtMagic)
+	psbtItem(&buf, []byte{psbtGlobalUnsignedTx})
+	psbtItem(&buf, serializeTx(tx))
+	//Unknown global keys are skipped.
+	psbtItem(&buf, []byte{0xfc, 0x01})
+	psbtItem(&buf, []byte{0xaa})
+	buf.WriteByte(0)
+
+	psbtItem(&buf, []byte{psbtInNonWitnessUtxo})
+	psbtItem(&buf, serializeTx(prevTx))
+	psbtItem(&buf, []byte{psbtInFinalScriptSig})
+	psbtItem(&buf, []byte{0x01, 0x02})
+	buf.WriteByte(0)
+
+	psbtItem(&buf, []byte{psbtInWitnessUtxo})
+	psbtItem(&buf, []byte{0x50, 0xc3, 0, 0, 0, 0, 0, 0, 0x01, 0x54})
+	buf.WriteByte(0)
+
+	//Output map.
+	buf.WriteByte(0)
+	return tx, buf.Bytes()
+}
+
+func TestDecodePsbt01(t *testing.T) {
+	tx, data := testPsbt()
+	psbt, err := DecodePsbt(data)
+	if err != nil {
+		t.Fatalf("Decode: %v.", err)
+	}
+	if len(psbt.Tx.TxIn) != 2 || psbt.Tx.TxOut[0].Value != tx.TxOut[0].Value {
+		t.Fatalf("Unsigned tx not decoded: %+v.", psbt.Tx)
+	}
+	if len(psbt.Inputs) != 2 {
+		t.Fatalf("Got %d input maps, expected 2.", len(psbt.Inputs))
+	}
+	in := psbt.Inputs[0]
+	if in.Utxo == nil || in.Utxo.Value != 70000 || !bytes.Equal(in.FinalScriptSig, []byte{0x01, 0x02}) {
+		t.Errorf("Input 0: got %+v.", in)
+	}
+	in = psbt.Inputs[1]
+	if in.Utxo == nil || in.Utxo.Value != 50000 || !bytes.Equal(in.Utxo.PkScript, []byte{0x54}) || in.FinalScriptSig != nil {
+		t.Errorf("Input 1: got %+v.", in)
+	}
+}
+
+func TestDecodePsbtInvalid01(t *testing.T) {
+	_, data := testPsbt()
+	if _, err := DecodePsbt(data[1:]); err != ErrNotPsbt {
+		t.Errorf("Expected ErrNotPsbt without magic, got %v.", err)
+	}
+	if _, err := DecodePsbt(append(append([]byte{}, psbtMagic...), 0)); err != ErrPsbtNoTx {
+		t.Errorf("Expected ErrPsbtNoTx, got %v.", err)
+	}
+	//Cut inside the second input map.
+	if _, err := DecodePsbt(data[:len(data)-4]); err == nil {
+		t.Error("Expected an error for a truncated PSBT.")
+	}
+}
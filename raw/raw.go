@@ -3,66 +3,112 @@ package raw
 import (
 	. "Assange/blockdata"
 	. "Assange/logging"
-	"fmt"
-	"github.com/conformal/btcutil"
-	//"github.com/conformal/btcwire"
+	"errors"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
 )
 
-var _ = fmt.Println
 var log = GetLogger("Raw", DEBUG)
 
-func NewTxFromRaw(raw []byte) *ModelTx {
-	tx, err := btcutil.NewTxFromBytes(raw)
+var ErrUnknownHeight = errors.New("Block height is neither in the coinbase nor known from the previous block.")
+
+// HeightLookup returns the height of an indexed block by hash, with an error
+// when the block is unknown.
+type HeightLookup func(hash Hash) (int64, error)
+
+// NewTxFromRaw decodes a serialized tx, with or without witness data, with
+// its inputs and classified outputs.
+func NewTxFromRaw(raw []byte) (*ModelTx, error) {
+	msgTx, size, err := DecodeMsgTx(raw)
 	if err != nil {
-		log.Error(err.Error())
+		return nil, err
 	}
 	modelTx := new(ModelTx)
-	modelTx.Hash = tx.Sha().String()
-	modelTx.IsCoinbase = false
-	msgTx := tx.MsgTx()
-	modelTx.Ver = msgTx.Version
-	modelTx.LockTime = msgTx.LockTime
-	modelTx.Txouts = NewTxoutsFromMsg(msgTx, modelTx)
-	modelTx.Txins = NewTxinsFromMsg(msgTx, modelTx)
-	return modelTx
+	if err := modelTx.NewFromMsg(msgTx); err != nil {
+		return nil, err
+	}
+	modelTx.Size = size.Vsize()
+	modelTx.IsCoinbase = isCoinbase(msgTx)
+	modelTx.NewInOutFromMsg()
+	return modelTx, nil
 }
 
-func NewBlockFromRaw(raw []byte) *ModelBlock {
-	block, err := btcutil.NewBlockFromBytes(raw)
+// NewBlockFromRaw decodes a serialized block, as published by ZMQ rawblock
+// or stored in blk*.dat, down to classified outputs. The height is taken
+// from lookup of the previous block, or from the BIP34 coinbase. lookup may
+// be nil.
+func NewBlockFromRaw(raw []byte, lookup HeightLookup) (*ModelBlock, error) {
+	msgBlock, size, txSizes, err := DecodeMsgBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+	height, err := blockHeight(msgBlock, lookup)
 	if err != nil {
-		log.Error(err.Error())
+		return nil, err
 	}
-	msgBlock := block.MsgBlock()
 	modelBlock := new(ModelBlock)
-	modelBlock.Height = block.Height()
-	if hash, err := block.Sha(); err != nil {
-		log.Error(err.Error())
-	} else {
-		modelBlock.Hash = hash.String()
+	if err := modelBlock.NewFromMsg(height, msgBlock); err != nil {
+		return nil, err
+	}
+	modelBlock.Size = size.Total
+	modelBlock.Weight = size.Weight()
+	for i, tx := range modelBlock.Txs {
+		tx.Size = txSizes[i].Vsize()
+		tx.NewInOutFromMsg()
+	}
+	return modelBlock, nil
+}
+
+func isCoinbase(msgTx *btcwire.MsgTx) bool {
+	if len(msgTx.TxIn) != 1 {
+		return false
 	}
-	modelBlock.PrevHash = msgBlock.Header.PrevBlock.String()
-	modelBlock.MerkleRoot = msgBlock.Header.MerkleRoot.String()
-	modelBlock.Time = msgBlock.Header.Timestamp
-	modelBlock.Ver = msgBlock.Header.Version
-	modelBlock.Nonce = msgBlock.Header.Nonce
-	modelBlock.Bits = msgBlock.Header.Bits
+	prev := msgTx.TxIn[0].PreviousOutPoint
+	return prev.Index == btcwire.MaxPrevOutIndex && prev.Hash == btcwire.ShaHash{}
+}
 
-	var tx *ModelTx
-	for idx, msgTx := range msgBlock.Transactions {
-		tx = new(ModelTx)
-		hash, err := msgTx.TxSha()
-		if err != nil {
-			log.Error(err.Error())
-		} else {
-			tx.Hash = hash.String()
+func blockHeight(msgBlock *btcwire.MsgBlock, lookup HeightLookup) (int64, error) {
+	if msgBlock.Header.PrevBlock == (btcwire.ShaHash{}) {
+		return 0, nil
+	}
+	if lookup != nil {
+		if prevHeight, err := lookup(NewHashFromSha(&msgBlock.Header.PrevBlock)); err == nil {
+			return prevHeight + 1, nil
+		}
+	}
+	if msgBlock.Header.Version >= 2 && len(msgBlock.Transactions) > 0 && len(msgBlock.Transactions[0].TxIn) > 0 {
+		height, ok := CoinbaseHeight(msgBlock.Transactions[0].TxIn[0].SignatureScript)
+		//Below the BIP34 height version 2 blocks may start it with anything.
+		if ok && height >= Bip34Height() {
+			return height, nil
+		}
+	}
+	return 0, ErrUnknownHeight
+}
+
+// CoinbaseHeight reads the BIP34 height pushed first in a coinbase script,
+// a minimal script number.
+func CoinbaseHeight(script []byte) (int64, bool) {
+	if len(script) == 0 {
+		return 0, false
+	}
+	op := script[0]
+	switch {
+	case op == btcscript.OP_0:
+		return 0, true
+	case op >= btcscript.OP_1 && op <= btcscript.OP_16:
+		return int64(op-btcscript.OP_1) + 1, true
+	case op >= 1 && op <= 8 && len(script) > int(op):
+		data := script[1 : 1+op]
+		//Negative heights do not exist.
+		if data[len(data)-1]&0x80 != 0 {
+			return 0, false
 		}
-		if idx == 0 {
-			tx.IsCoinbase = true
-		} else {
-			tx.IsCoinbase = false
+		var height int64
+		for i := len(data) - 1; i >= 0; i-- {
+			height = height<<8 | int64(data[i])
 		}
-		modelBlock.Txs = append(modelBlock.Txs, tx)
-		//modelBlock.Transactions = append(modelBlock.Transactions, hash.Bytes()...)
+		return height, true
 	}
-	return modelBlock
+	return 0, false
 }
@@ -0,0 +1,75 @@
+package raw
+
+import (
+	. "Assange/blockdata"
+	"encoding/hex"
+	"errors"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+func TestCoinbaseHeight01(t *testing.T) {
+	cases := []struct {
+		script string
+		height int64
+		ok     bool
+	}{
+		//Coinbase of block 227931, the first with an enforced BIP34 height.
+		{"035b7a030453", 227931, true},
+		{"0340420f", 1000000, true},
+		{"00", 0, true},
+		{"51", 1, true},
+		{"60", 16, true},
+		{"0180", 0, false},
+		{"03ffff", 0, false},
+		{"", 0, false},
+	}
+	for _, c := range cases {
+		script, _ := hex.DecodeString(c.script)
+		height, ok := CoinbaseHeight(script)
+		if height != c.height || ok != c.ok {
+			t.Errorf("%s: got %d %v, expected %d %v.", c.script, height, ok, c.height, c.ok)
+		}
+	}
+}
+
+func testBlock(version int32, coinbase string) *btcwire.MsgBlock {
+	script, _ := hex.DecodeString(coinbase)
+	msg := &btcwire.MsgBlock{Header: btcwire.BlockHeader{Version: version, PrevBlock: btcwire.ShaHash{1}}}
+	msg.Transactions = []*btcwire.MsgTx{{TxIn: []*btcwire.TxIn{{SignatureScript: script}}}}
+	return msg
+}
+
+func TestBlockHeight01(t *testing.T) {
+	known := func(hash Hash) (int64, error) { return 499, nil }
+	unknown := func(hash Hash) (int64, error) { return 0, errors.New("not found") }
+
+	if height, err := blockHeight(testBlock(2, "035b7a03"), known); err != nil || height != 500 {
+		t.Errorf("Lookup: got %d %v, expected 500.", height, err)
+	}
+	if height, err := blockHeight(testBlock(2, "035b7a03"), unknown); err != nil || height != 227931 {
+		t.Errorf("BIP34: got %d %v, expected 227931.", height, err)
+	}
+	//Before activation the coinbase height can not be trusted.
+	if _, err := blockHeight(testBlock(2, "03e80300"), nil); err != ErrUnknownHeight {
+		t.Errorf("Expected ErrUnknownHeight below the BIP34 height, got %v.", err)
+	}
+	//Height 100000, past the BIP34 height on testnet only.
+	if _, err := blockHeight(testBlock(2, "03a08601"), nil); err != ErrUnknownHeight {
+		t.Errorf("Expected ErrUnknownHeight below the mainnet BIP34 height, got %v.", err)
+	}
+	SetTestnet(true)
+	height, err := blockHeight(testBlock(2, "03a08601"), nil)
+	SetTestnet(false)
+	if err != nil || height != 100000 {
+		t.Errorf("Testnet BIP34: got %d %v, expected 100000.", height, err)
+	}
+	if _, err := blockHeight(testBlock(1, "035b7a03"), nil); err != ErrUnknownHeight {
+		t.Errorf("Expected ErrUnknownHeight for a version 1 block, got %v.", err)
+	}
+	genesis := testBlock(1, "04ffff001d")
+	genesis.Header.PrevBlock = btcwire.ShaHash{}
+	if height, err := blockHeight(genesis, nil); err != nil || height != 0 {
+		t.Errorf("Genesis: got %d %v, expected 0.", height, err)
+	}
+}
@@ -0,0 +1,247 @@
+package webhook
+
+import (
+	. "Assange/blockdata"
+	. "Assange/logging"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+var log = GetLogger("Webhook", DEBUG)
+
+const (
+	//Attempts before a delivery is dead.
+	MaxAttempts    = 10
+	firstRetry     = 30 * time.Second
+	maxRetry       = 6 * time.Hour
+	requestTimeout = 10 * time.Second
+	pollInterval   = time.Second
+	batchSize      = 100
+	//POSTs in flight at once, so one slow receiver does not hold up the rest.
+	parallelPosts = 8
+	maxErrorLen   = 255
+)
+
+// Headers of every POST. The signature is the hex HMAC-SHA256, keyed with
+// the webhook secret, of the timestamp, a dot and the body.
+const (
+	HeaderDelivery  = "X-Assange-Delivery"
+	HeaderTimestamp = "X-Assange-Timestamp"
+	HeaderSignature = "X-Assange-Signature"
+)
+
+var errPrivateAddress = errors.New("Webhook URL resolves to a private address.")
+
+// Receivers on these networks could not be reached from outside, so a
+// public API must not POST to them on a client's behalf.
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10"} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, ipNet)
+	}
+}
+
+func isPrivate(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, ipNet := range privateNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Worker POSTs the pending deliveries of the outbox the indexer fills.
+// Deliveries are at least once: a POST is repeated if the process stops
+// before its outcome is recorded.
+type Worker struct {
+	dbmap  *gorp.DbMap
+	client *http.Client
+}
+
+// NewWorker refuses receivers on private networks unless allowPrivate is
+// set, which is meant for receivers run next to the indexer.
+func NewWorker(dbmap *gorp.DbMap, allowPrivate bool) *Worker {
+	return &Worker{dbmap: dbmap, client: NewClient(allowPrivate)}
+}
+
+// NewClient returns the HTTP client deliveries are POSTed with. Redirects
+// are not followed and count as failures.
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: requestTimeout}
+	if !allowPrivate {
+		//Checked on the address actually dialed, after DNS resolution.
+		dialer.Control = func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: requestTimeout},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Run delivers due deliveries forever.
+func (w *Worker) Run() {
+	log.Info("Webhook delivery started.")
+	for {
+		n, err := w.deliverDue()
+		if err != nil {
+			log.Error(err.Error())
+		}
+		if n < batchSize {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+func (w *Worker) deliverDue() (int, error) {
+	due, err := DueWebhookDeliveries(w.dbmap, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+	var wait sync.WaitGroup
+	slots := make(chan struct{}, parallelPosts)
+	for _, d := range due {
+		wait.Add(1)
+		slots <- struct{}{}
+		go func(d *DueWebhookDelivery) {
+			defer wait.Done()
+			w.deliver(d)
+			<-slots
+		}(d)
+	}
+	wait.Wait()
+	return len(due), nil
+}
+
+func (w *Worker) deliver(d *DueWebhookDelivery) {
+	start := time.Now()
+	code, err := Post(w.client, d.Url, d.Secret, d.Id, []byte(d.Payload), start)
+	attempt := &ModelWebhookAttempt{
+		DeliveryId: d.Id,
+		Time:       start,
+		StatusCode: int64(code),
+		Duration:   int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		attempt.Error = truncate(err.Error(), maxErrorLen)
+	}
+	status, next := outcome(d.Attempts+1, err, start)
+	if status == WebhookDead {
+		log.Warning("Webhook delivery %d is dead after %d attempts: %s", d.Id, d.Attempts+1, attempt.Error)
+	}
+	trans, err := w.dbmap.Begin()
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	if err := RecordWebhookAttempt(trans, attempt, status, next); err != nil {
+		trans.Rollback()
+		log.Error(err.Error())
+		return
+	}
+	if err := trans.Commit(); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// outcome returns the status of a delivery after its attempts-th attempt
+// failed with err, and when to try again.
+func outcome(attempts int64, err error, now time.Time) (string, time.Time) {
+	switch {
+	case err == nil:
+		return WebhookDelivered, now
+	case attempts >= MaxAttempts:
+		return WebhookDead, now
+	}
+	return WebhookPending, now.Add(Backoff(attempts))
+}
+
+// Backoff is the wait after the given number of failed attempts, doubling
+// from 30 seconds up to 6 hours.
+func Backoff(attempts int64) time.Duration {
+	wait := firstRetry
+	for i := int64(1); i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+	return wait
+}
+
+// Sign returns the signature header of body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post sends one delivery and returns the response status, 0 if there was
+// no response. Responses other than 2xx are errors.
+func Post(client *http.Client, url string, secret string, deliveryId int64, body []byte, now time.Time) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Assange-Webhook")
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(deliveryId, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	//Read to the end, so the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Receiver answered %s.", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	. "Assange/blockdata"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPost01(t *testing.T) {
+	body := []byte(`{"event":"tx"}`)
+	now := time.Unix(1400000000, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got, _ := ioutil.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte("1400000000." + string(got)))
+		if req.Header.Get(HeaderSignature) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Bad signature %s.", req.Header.Get(HeaderSignature))
+		}
+		if req.Header.Get(HeaderDelivery) != "42" || req.Header.Get(HeaderTimestamp) != "1400000000" {
+			t.Errorf("Unexpected headers: %v.", req.Header)
+		}
+		if string(got) != string(body) {
+			t.Errorf("Unexpected body %s.", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	code, err := Post(NewClient(true), server.URL, "secret", 42, body, now)
+	if err != nil || code != http.StatusNoContent {
+		t.Errorf("Delivery failed: %d %v.", code, err)
+	}
+}
+
+func TestPostFailure01(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/moved" {
+			http.Redirect(w, req, "/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		path string
+		code int
+	}{{"/", http.StatusInternalServerError}, {"/moved", http.StatusFound}} {
+		code, err := Post(NewClient(true), server.URL+c.path, "secret", 1, []byte("{}"), time.Now())
+		if err == nil || code != c.code {
+			t.Errorf("%s: got %d %v.", c.path, code, err)
+		}
+	}
+}
+
+func TestPostPrivate01(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("Private receiver reached.")
+	}))
+	defer server.Close()
+
+	code, err := Post(NewClient(false), server.URL, "secret", 1, []byte("{}"), time.Now())
+	if err == nil || code != 0 {
+		t.Errorf("Private receiver allowed: %d %v.", code, err)
+	}
+}
+
+func TestIsPrivate01(t *testing.T) {
+	for _, c := range []struct {
+		ip      string
+		private bool
+	}{{"127.0.0.1", true}, {"10.1.2.3", true}, {"192.168.0.1", true}, {"::1", true}, {"0.0.0.0", true},
+		{"8.8.8.8", false}, {"2001:4860:4860::8888", false}} {
+		if isPrivate(net.ParseIP(c.ip)) != c.private {
+			t.Errorf("%s: expected private %v.", c.ip, c.private)
+		}
+	}
+}
+
+func TestBackoff01(t *testing.T) {
+	cases := map[int64]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 9: 128 * time.Minute, 20: 6 * time.Hour}
+	for attempts, wait := range cases {
+		if got := Backoff(attempts); got != wait {
+			t.Errorf("Backoff after %d attempts is %s, expected %s.", attempts, got, wait)
+		}
+	}
+}
+
+func TestOutcome01(t *testing.T) {
+	now := time.Now()
+	failed := errors.New("failed")
+	if status, _ := outcome(1, nil, now); status != WebhookDelivered {
+		t.Errorf("Successful delivery is %s.", status)
+	}
+	if status, next := outcome(1, failed, now); status != WebhookPending || next != now.Add(firstRetry) {
+		t.Errorf("First failure is %s, retried at %s.", status, next)
+	}
+	if status, _ := outcome(MaxAttempts, failed, now); status != WebhookDead {
+		t.Errorf("Last failure is %s.", status)
+	}
+}
+
+func TestTruncate01(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abc", 3, "abc"},
+		{"abcd", 3, "abc"},
+		//é and ü take 2 bytes.
+		{"aé", 2, "a"},
+		{"aéü", 4, "aé"},
+		{"é", 1, ""},
+	} {
+		if got := truncate(c.s, c.n); got != c.want {
+			t.Errorf("truncate(%q, %d) is %q, expected %q.", c.s, c.n, got, c.want)
+		}
+	}
+}
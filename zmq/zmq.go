@@ -1,60 +1,226 @@
 package zmq
 
 import (
-	//. "Assange/blockdata"
-	//	. "Assange/raw"
+	. "Assange/blockdata"
+	"Assange/config"
+	. "Assange/logging"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	zmq "github.com/alecthomas/gozmq"
-	//"github.com/go-sql-driver/mysql"
-	"github.com/coopernurse/gorp"
 )
 
-var _ = fmt.Println
-var topic1 = "BLK"
-var topic2 = "TXN"
-var topic_len = len(topic1)
+const (
+	TopicRawBlock  = "rawblock"
+	TopicRawTx     = "rawtx"
+	TopicHashBlock = "hashblock"
+	TopicHashTx    = "hashtx"
+	TopicSequence  = "sequence"
+)
+
+// Labels of the sequence topic.
+const (
+	SequenceBlockConnected    = 'C'
+	SequenceBlockDisconnected = 'D'
+	SequenceTxAdded           = 'A'
+	SequenceTxRemoved         = 'R'
+)
+
+var log = GetLogger("ZMQ", DEBUG)
+
+var ErrNoZmqEndpoint = errors.New("No ZMQ endpoint configured.")
+var ErrBadMessage = errors.New("Malformed ZMQ message.")
+
+// Handler receives decoded notifications, always from the goroutine running
+// HandleZmq. Hashes are in the usual display byte order. Raw blocks and txs
+// are passed serialized, they may carry witness data which btcwire can not
+// decode.
+type Handler interface {
+	HandleRawBlock(raw []byte) error
+	HandleRawTx(raw []byte) error
+	HandleBlockHash(hash Hash) error
+	HandleTxHash(hash Hash) error
+	HandleBlockDisconnected(hash Hash) error
+	HandleTxRemoved(hash Hash) error
+	//Resync catches up with whatever notifications may have been missed.
+	Resync() error
+}
+
+// Notification is one multipart message: topic, body and the per-topic
+// sequence number bitcoind appends.
+type Notification struct {
+	Topic string
+	Body  []byte
+	Seq   uint32
+}
+
+var context *zmq.Context
 var socket *zmq.Socket
-var dbmap *gorp.DbMap
+var handler Handler
+var tracker = newSequenceTracker()
+
+// sequenceTracker remembers the last sequence number of every topic.
+// bitcoind counts each topic separately and starts over at 0 when it
+// restarts, messages dropped at the high-water mark leave a hole.
+type sequenceTracker struct {
+	last map[string]uint32
+}
 
-func InitZmq(db *gorp.DbMap) {
-	context, _ := zmq.NewContext()
-	socket, _ = context.NewSocket(zmq.SUB)
-	socket.Connect("tcp://127.0.0.1:5000")
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{last: make(map[string]uint32)}
+}
+
+// gap records n and reports whether messages of its topic were missed.
+// The first message of a topic only sets the baseline.
+func (t *sequenceTracker) gap(n *Notification) bool {
+	last, seen := t.last[n.Topic]
+	t.last[n.Topic] = n.Seq
+	if !seen || n.Seq == last+1 {
+		return false
+	}
+	if n.Seq <= last {
+		log.Warning("Topic %s restarted at sequence %d after %d, bitcoind reconnected.", n.Topic, n.Seq, last)
+	} else {
+		log.Warning("Topic %s missed %d messages before sequence %d.", n.Topic, n.Seq-last-1, n.Seq)
+	}
+	return true
+}
+
+// InitZmq subscribes to every topic with a configured endpoint. Topics may
+// share an endpoint, as with bitcoind's -zmqpub* options.
+func InitZmq(conf config.Configuration, h Handler) error {
+	endpoints := map[string]string{
+		TopicRawBlock:  conf.Zmq_rawblock,
+		TopicRawTx:     conf.Zmq_rawtx,
+		TopicHashBlock: conf.Zmq_hashblock,
+		TopicHashTx:    conf.Zmq_hashtx,
+		TopicSequence:  conf.Zmq_sequence,
+	}
+	var err error
+	if context, err = zmq.NewContext(); err != nil {
+		return err
+	}
+	if socket, err = context.NewSocket(zmq.SUB); err != nil {
+		return err
+	}
+	connected := make(map[string]bool)
+	for topic, endpoint := range endpoints {
+		if endpoint == "" {
+			continue
+		}
+		if !connected[endpoint] {
+			if err := socket.Connect(endpoint); err != nil {
+				return err
+			}
+			connected[endpoint] = true
+		}
+		if err := socket.SetSockOptString(zmq.SUBSCRIBE, topic); err != nil {
+			return err
+		}
+		log.Info("Subscribed to %s at %s.", topic, endpoint)
+	}
+	if len(connected) == 0 {
+		return ErrNoZmqEndpoint
+	}
+	handler = h
+	return nil
+}
 
-	socket.SetSockOptString(zmq.SUBSCRIBE, topic1)
-	socket.SetSockOptString(zmq.SUBSCRIBE, topic2)
-	dbmap = db
+// ZmqEnabled reports whether any ZMQ endpoint is configured.
+func ZmqEnabled(conf config.Configuration) bool {
+	return conf.Zmq_rawblock != "" || conf.Zmq_rawtx != "" || conf.Zmq_hashblock != "" ||
+		conf.Zmq_hashtx != "" || conf.Zmq_sequence != ""
 }
 
+// HandleZmq receives notifications forever and passes them to the handler.
+// Handler errors are logged, the next notification is processed anyway.
+// Anything missed before the start or in a sequence gap is caught up by
+// the handler's Resync.
 func HandleZmq() {
+	resync()
 	for {
-		msg, _ := socket.Recv(0)
-		topic := string(msg[0:topic_len])
-		content := msg[topic_len:]
-		if topic == "BLK" {
-			HandleBlk(content)
-		} else if topic == "TXN" {
-			//HandleTxn(content)
-		}
-	}
-}
-
-func HandleTxn(raw []byte) {
-	//trans, _ := dbmap.Begin()
-	//tx := NewTxFromRaw(raw)
-	//tx = InsertTxIntoDB(trans, tx)
-	//for _, txout := range tx.Txouts {
-	//	InsertTxoutIntoDb(trans, txout)
-	//}
-	//for _, txin := range tx.Txins {
-	//	InsertTxinIntoDb(trans, txin)
-	//}
-	//trans.Commit()
-}
-
-func HandleBlk(raw []byte) {
-	//trans, _ := dbmap.Begin()
-	//block := NewBlockFromRaw(raw)
-	//InsertBlockOnlyIntoDb(trans, block)
-	//trans.Commit()
+		parts, err := socket.RecvMultipart(0)
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		n, err := parseMessage(parts)
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		if err := dispatch(n); err != nil {
+			log.Error("Handle %s %d: %s", n.Topic, n.Seq, err.Error())
+		}
+		if tracker.gap(n) {
+			resync()
+		}
+	}
+}
+
+func resync() {
+	log.Info("Resync with bitcoind.")
+	if err := handler.Resync(); err != nil {
+		log.Error("Resync failed: %s", err.Error())
+	}
+}
+
+func parseMessage(parts [][]byte) (*Notification, error) {
+	if len(parts) != 3 || len(parts[2]) != 4 {
+		return nil, ErrBadMessage
+	}
+	return &Notification{
+		Topic: string(parts[0]),
+		Body:  parts[1],
+		Seq:   binary.LittleEndian.Uint32(parts[2]),
+	}, nil
+}
+
+func hashOf(body []byte) (Hash, error) {
+	var hash Hash
+	if len(body) < len(hash) {
+		return hash, ErrBadMessage
+	}
+	copy(hash[:], body)
+	return hash, nil
+}
+
+func dispatch(n *Notification) error {
+	switch n.Topic {
+	case TopicRawBlock:
+		return handler.HandleRawBlock(n.Body)
+	case TopicRawTx:
+		return handler.HandleRawTx(n.Body)
+	case TopicHashBlock:
+		hash, err := hashOf(n.Body)
+		if err != nil {
+			return err
+		}
+		return handler.HandleBlockHash(hash)
+	case TopicHashTx:
+		hash, err := hashOf(n.Body)
+		if err != nil {
+			return err
+		}
+		return handler.HandleTxHash(hash)
+	case TopicSequence:
+		//32 byte hash, a label and for mempool events an 8 byte mempool sequence.
+		hash, err := hashOf(n.Body)
+		if err != nil || len(n.Body) < 33 {
+			return ErrBadMessage
+		}
+		switch n.Body[32] {
+		case SequenceBlockConnected:
+			return handler.HandleBlockHash(hash)
+		case SequenceBlockDisconnected:
+			return handler.HandleBlockDisconnected(hash)
+		case SequenceTxAdded:
+			return handler.HandleTxHash(hash)
+		case SequenceTxRemoved:
+			return handler.HandleTxRemoved(hash)
+		}
+		return fmt.Errorf("Unknown sequence label %q.", n.Body[32])
+	}
+	log.Debug("Ignore topic %s.", n.Topic)
+	return nil
 }
@@ -1,9 +1,105 @@
 package zmq
 
 import (
+	. "Assange/blockdata"
 	"testing"
 )
 
-func TestInitZmq01(t *testing.T) {
-	InitZmq()
+type testHandler struct {
+	events []string
+	hashes []Hash
+}
+
+func (h *testHandler) HandleRawBlock(raw []byte) error {
+	h.events = append(h.events, "rawblock")
+	return nil
+}
+
+func (h *testHandler) HandleRawTx(raw []byte) error {
+	h.events = append(h.events, "rawtx")
+	return nil
+}
+
+func (h *testHandler) add(event string, hash Hash) error {
+	h.events = append(h.events, event)
+	h.hashes = append(h.hashes, hash)
+	return nil
+}
+
+func (h *testHandler) HandleBlockHash(hash Hash) error         { return h.add("block", hash) }
+func (h *testHandler) HandleTxHash(hash Hash) error            { return h.add("tx", hash) }
+func (h *testHandler) HandleBlockDisconnected(hash Hash) error { return h.add("disconnected", hash) }
+func (h *testHandler) HandleTxRemoved(hash Hash) error         { return h.add("removed", hash) }
+
+func (h *testHandler) Resync() error {
+	h.events = append(h.events, "resync")
+	return nil
+}
+
+func testHash(b byte) []byte {
+	hash := make([]byte, 32)
+	hash[0] = b
+	return hash
+}
+
+func TestParseMessage01(t *testing.T) {
+	n, err := parseMessage([][]byte{[]byte("hashblock"), testHash(1), {0x02, 0x01, 0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Topic != TopicHashBlock || n.Seq != 258 || n.Body[0] != 1 {
+		t.Errorf("Unexpected notification %+v.", n)
+	}
+	if _, err := parseMessage([][]byte{[]byte("hashblock"), testHash(1)}); err != ErrBadMessage {
+		t.Errorf("Expected ErrBadMessage without a sequence, got %v.", err)
+	}
+}
+
+func TestDispatchSequence01(t *testing.T) {
+	h := new(testHandler)
+	handler = h
+	for i, label := range []byte{SequenceBlockConnected, SequenceBlockDisconnected, SequenceTxAdded, SequenceTxRemoved} {
+		body := append(testHash(byte(i)), label)
+		if label == SequenceTxAdded || label == SequenceTxRemoved {
+			body = append(body, 1, 0, 0, 0, 0, 0, 0, 0)
+		}
+		if err := dispatch(&Notification{Topic: TopicSequence, Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"block", "disconnected", "tx", "removed"}
+	for i, event := range expected {
+		if i >= len(h.events) || h.events[i] != event || h.hashes[i][0] != byte(i) {
+			t.Errorf("Event %d: got %v, expected %s.", i, h.events, event)
+		}
+	}
+	if err := dispatch(&Notification{Topic: TopicSequence, Body: testHash(0)}); err != ErrBadMessage {
+		t.Errorf("Expected ErrBadMessage without a label, got %v.", err)
+	}
+}
+
+func TestSequenceGap01(t *testing.T) {
+	tracker := newSequenceTracker()
+	steps := []struct {
+		topic string
+		seq   uint32
+		gap   bool
+	}{
+		{TopicRawBlock, 7, false},
+		{TopicRawTx, 100, false},
+		{TopicRawBlock, 8, false},
+		{TopicRawTx, 101, false},
+		{TopicRawBlock, 10, true},
+		{TopicRawBlock, 11, false},
+		//bitcoind restarted.
+		{TopicRawBlock, 0, true},
+		{TopicRawBlock, 1, false},
+		{TopicRawTx, 0xffffffff, true},
+		{TopicRawTx, 0, false},
+	}
+	for i, step := range steps {
+		if gap := tracker.gap(&Notification{Topic: step.topic, Seq: step.seq}); gap != step.gap {
+			t.Errorf("Step %d, %s %d: gap is %v, expected %v.", i, step.topic, step.seq, gap, step.gap)
+		}
+	}
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	. "Assange/bitcoinrpc"
+	. "Assange/blockdata"
+	"Assange/raw"
+)
+
+// zmqHandler feeds the indexer from bitcoind's ZMQ notifications. Whatever
+// does not fit on the indexed tip is caught up over RPC.
+type zmqHandler struct {
+	*indexer
+}
+
+func (h *zmqHandler) HandleRawBlock(body []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	block, err := raw.NewBlockFromRaw(body, h.blockHeight)
+	if err == raw.ErrUnknownHeight {
+		return h.catchUp()
+	}
+	if err != nil {
+		return err
+	}
+	if known, err := h.hasBlock(block.Hash); known || err != nil {
+		return err
+	}
+	tip, err := h.tip()
+	if err != nil {
+		return err
+	}
+	if tip != nil && block.PrevHash == tip.Hash {
+		return h.storeBlock(block)
+	}
+	known, err := h.hasBlock(block.PrevHash)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return h.catchUp()
+	}
+	//A sibling of an indexed block, bitcoind switched to another branch.
+	log.Warning("Block %s does not extend the indexed tip, following the reorg.", block.Hash)
+	return h.catchUp()
+}
+
+func (h *zmqHandler) HandleRawTx(body []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	tx, err := raw.NewTxFromRaw(body)
+	if err != nil {
+		return err
+	}
+	return h.storeTx(tx)
+}
+
+// blockHeight is the raw.HeightLookup of indexed blocks.
+func (h *zmqHandler) blockHeight(hash Hash) (int64, error) {
+	block, err := h.queries.BlockByHash(hash)
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
+func (h *zmqHandler) HandleBlockHash(hash Hash) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if known, err := h.hasBlock(hash); known || err != nil {
+		return err
+	}
+	return h.catchUp()
+}
+
+func (h *zmqHandler) HandleTxHash(hash Hash) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.fetchTx(hash)
+}
+
+func (h *zmqHandler) HandleBlockDisconnected(hash Hash) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	//Rolled back and announced by the catch up once the new branch is
+	//connected.
+	log.Warning("Block %s disconnected by bitcoind.", hash)
+	return nil
+}
+
+func (h *zmqHandler) HandleTxRemoved(hash Hash) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.removeTx(hash)
+}
+
+// Resync rescans blocks from the DB tip and diffs the indexed mempool
+// against getrawmempool. Missing txs are fetched parents first, so their
+// inputs resolve.
+func (h *zmqHandler) Resync() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if err := h.catchUp(); err != nil {
+		return err
+	}
+	txids, err := RpcGetrawmempoolOrdered()
+	if err != nil {
+		return err
+	}
+	ordered := make([]Hash, len(txids))
+	inNode := make(map[Hash]bool, len(txids))
+	for i, txid := range txids {
+		hash, err := NewHashFromStr(txid)
+		if err != nil {
+			return err
+		}
+		ordered[i] = hash
+		inNode[hash] = true
+	}
+	indexed, err := UnconfirmedTxs(h.dbmap)
+	if err != nil {
+		return err
+	}
+	removed, added := 0, 0
+	for _, tx := range indexed {
+		if inNode[tx.Hash] {
+			delete(inNode, tx.Hash)
+			continue
+		}
+		if err := h.removeTx(tx.Hash); err != nil {
+			return err
+		}
+		removed++
+	}
+	for _, hash := range ordered {
+		if !inNode[hash] {
+			continue
+		}
+		if err := h.fetchTx(hash); err != nil {
+			return err
+		}
+		added++
+	}
+	log.Info("Mempool resynced, %d txs added, %d removed.", added, removed)
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	. "Assange/blockdata"
+	"Assange/config"
+	"Assange/notify"
+	"bytes"
+	"database/sql"
+	"github.com/conformal/btcwire"
+	"github.com/coopernurse/gorp"
+	"testing"
+)
+
+// Wiped by the tests, so not the database of a real index.
+var testDbConfig = config.Configuration{
+	Db_host:     "127.0.0.1",
+	Db_database: "assange_test",
+	Db_user:     "assange_test",
+	Db_password: "assange_test",
+}
+
+func openTestDb(t *testing.T) (*gorp.DbMap, *Queries) {
+	dbmap, err := InitDb(testDbConfig)
+	if err == nil {
+		err = dbmap.Db.Ping()
+	}
+	if err != nil {
+		t.Skipf("No test database %s on %s: %s", testDbConfig.Db_database, testDbConfig.Db_host, err)
+	}
+	InitTables(dbmap)
+	if err := MigrateDb(dbmap); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"block", "tx", "blocktx", "txin", "txout", "script", "address", "txoutaddress", "webhookdelivery"} {
+		if _, err := dbmap.Exec("delete from " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dbmap.Exec("update webhookmark set BlockHeight=-1, TxId=0"); err != nil {
+		t.Fatal(err)
+	}
+	queries, err := PrepareQueries(dbmap.Db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbmap, queries
+}
+
+func rawBlock(block *btcwire.MsgBlock) []byte {
+	var buf bytes.Buffer
+	block.Serialize(&buf)
+	return buf.Bytes()
+}
+
+func TestHandleRawBlockReorg01(t *testing.T) {
+	dbmap, queries := openTestDb(t)
+	defer queries.Close()
+	verbosity := Config.Rpc_block_verbosity
+	Config.Rpc_block_verbosity = 2
+	defer func() { Config.Rpc_block_verbosity = verbosity }()
+
+	//The genesis txs are not indexed, so the fork is above a common block.
+	genesis := testBlock(nil, 0)
+	common := testBlock(genesis, 'c')
+	commonCoinbase, _ := common.Transactions[0].TxSha()
+	//Spends the common coinbase, mined on the stale branch only.
+	spend := &btcwire.MsgTx{
+		Version: 1,
+		TxIn: []*btcwire.TxIn{{
+			PreviousOutPoint: btcwire.OutPoint{Hash: commonCoinbase},
+			SignatureScript:  []byte{0x00},
+			Sequence:         0xffffffff,
+		}},
+		TxOut: []*btcwire.TxOut{{Value: 4999990000, PkScript: common.Transactions[0].TxOut[0].PkScript}},
+	}
+	a2 := testBlock(common, 'a')
+	a3 := testBlock(a2, 'a', spend)
+	b2 := testBlock(common, 'b')
+	b3 := testBlock(b2, 'b')
+	b4 := testBlock(b3, 'b')
+
+	node := &testNode{chain: []*btcwire.MsgBlock{genesis, common, a2, a3}}
+	ts := startTestNode(t, node)
+	defer ts.Close()
+	h := &zmqHandler{newIndexer(dbmap, queries)}
+	if err := h.Resync(); err != nil {
+		t.Fatal(err)
+	}
+
+	//bitcoind switches to the longer branch and announces its blocks.
+	node.chain = []*btcwire.MsgBlock{genesis, common, b2, b3, b4}
+	node.mempool = []*btcwire.MsgTx{spend}
+	sub := notify.Subscribe(100, func(e *notify.Event) bool { return e.Type == notify.BlockDisconnected })
+	defer sub.Close()
+	for _, block := range []*btcwire.MsgBlock{b2, b3, b4} {
+		if err := h.HandleRawBlock(rawBlock(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//Tip first, the spend returned to the mempool with its block.
+	for _, c := range []struct {
+		block *btcwire.MsgBlock
+		txs   int
+	}{{a3, 1}, {a2, 0}} {
+		e := <-sub.C
+		if e.Hash != testBlockHash(c.block) || len(e.Txs) != c.txs || (c.txs > 0 && e.Txs[0] != testTxHash(spend)) {
+			t.Errorf("Got %+v, expected %s with %d txs.", e, testBlockHash(c.block), c.txs)
+		}
+	}
+	if len(sub.C) != 0 {
+		t.Errorf("%d more disconnects announced.", len(sub.C))
+	}
+
+	for height, block := range node.chain {
+		hash, _ := NewHashFromStr(testBlockHash(block))
+		indexed, err := queries.BlockByHeight(int64(height))
+		if err != nil || indexed.Hash != hash {
+			t.Fatalf("Height %d: got %v, %v, expected %s.", height, indexed, err, hash)
+		}
+	}
+	for _, block := range []*btcwire.MsgBlock{a2, a3} {
+		hash, _ := NewHashFromStr(testBlockHash(block))
+		if known, _ := h.hasBlock(hash); known {
+			t.Errorf("Stale block %s still indexed.", hash)
+		}
+		coinbase, _ := NewHashFromStr(testTxHash(block.Transactions[0]))
+		if _, err := queries.TxByHash(coinbase); err != sql.ErrNoRows {
+			t.Errorf("Stale coinbase %s still indexed: %v.", coinbase, err)
+		}
+	}
+	commonHash, _ := NewHashFromStr(testBlockHash(common))
+	if indexed, _ := queries.BlockByHash(commonHash); indexed.NextHash.String() != testBlockHash(b2) {
+		t.Errorf("Fork point is followed by %s, expected %s.", indexed.NextHash, testBlockHash(b2))
+	}
+
+	//The spend is back in the mempool and still spends the common coinbase.
+	spendHash, _ := NewHashFromStr(testTxHash(spend))
+	tx, err := queries.TxByHash(spendHash)
+	if err != nil || tx.Confirmed {
+		t.Fatalf("Spend got %v, %v, expected it unconfirmed.", tx, err)
+	}
+	out, err := queries.TxoutByOutpoint(NewHashFromSha(&commonCoinbase), 0)
+	if err != nil || !out.Spent {
+		t.Errorf("Common coinbase output got %v, %v, expected it spent.", out, err)
+	}
+}